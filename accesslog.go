@@ -0,0 +1,254 @@
+package apexovernsq
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// AccessLogOptions tunes the entries made by NewAccessLogMiddleware.
+type AccessLogOptions struct {
+	// SampleSuccess, when greater than 1, causes only one in
+	// every SampleSuccess requests with a 2xx status to be
+	// logged.  Other responses are always logged.
+	SampleSuccess int
+	// SkipPaths lists request paths, such as health checks, that
+	// are never logged.
+	SkipPaths []string
+	// Skip, if set, is called for every request and prevents it
+	// from being logged when it returns true.
+	Skip func(r *http.Request) bool
+	// Message is the message of every entry.  It defaults to
+	// "request".
+	Message string
+}
+
+// statusRecorder is an http.ResponseWriter that remembers the status
+// and size of the response written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// hijack, flush and push pass calls through to the underlying
+// ResponseWriter.  They are only reachable through the writer
+// returned by wrap, when the underlying ResponseWriter supports them.
+// A hijacked connection is logged with a 101 status unless another
+// status was written.
+func (w *statusRecorder) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *statusRecorder) flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *statusRecorder) push(target string, opts *http.PushOptions) error {
+	return w.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+// Unwrap returns the underlying ResponseWriter, for the benefit of
+// http.ResponseController.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type flusherFunc func()
+
+func (f flusherFunc) Flush() { f() }
+
+type hijackerFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func (f hijackerFunc) Hijack() (net.Conn, *bufio.ReadWriter, error) { return f() }
+
+type pusherFunc func(string, *http.PushOptions) error
+
+func (f pusherFunc) Push(target string, opts *http.PushOptions) error { return f(target, opts) }
+
+// wrap returns the statusRecorder as an http.ResponseWriter that
+// implements http.Flusher, http.Hijacker and http.Pusher exactly when
+// the underlying ResponseWriter does, so that streaming responses,
+// websocket upgrades and server push keep working behind the
+// middleware.
+func (w *statusRecorder) wrap() http.ResponseWriter {
+	_, flushes := w.ResponseWriter.(http.Flusher)
+	_, hijacks := w.ResponseWriter.(http.Hijacker)
+	_, pushes := w.ResponseWriter.(http.Pusher)
+	flusher, hijacker, pusher := flusherFunc(w.flush), hijackerFunc(w.hijack), pusherFunc(w.push)
+	switch {
+	case flushes && hijacks && pushes:
+		return struct {
+			*statusRecorder
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{w, flusher, hijacker, pusher}
+	case flushes && hijacks:
+		return struct {
+			*statusRecorder
+			http.Flusher
+			http.Hijacker
+		}{w, flusher, hijacker}
+	case flushes && pushes:
+		return struct {
+			*statusRecorder
+			http.Flusher
+			http.Pusher
+		}{w, flusher, pusher}
+	case hijacks && pushes:
+		return struct {
+			*statusRecorder
+			http.Hijacker
+			http.Pusher
+		}{w, hijacker, pusher}
+	case flushes:
+		return struct {
+			*statusRecorder
+			http.Flusher
+		}{w, flusher}
+	case hijacks:
+		return struct {
+			*statusRecorder
+			http.Hijacker
+		}{w, hijacker}
+	case pushes:
+		return struct {
+			*statusRecorder
+			http.Pusher
+		}{w, pusher}
+	}
+	return w
+}
+
+// accessLogger is the http.Handler returned by
+// NewAccessLogMiddleware.
+type accessLogger struct {
+	ctx       *log.Entry
+	next      http.Handler
+	options   AccessLogOptions
+	skipPaths map[string]bool
+	successes uint64
+}
+
+// NewAccessLogMiddleware wraps an http.Handler so that one entry is
+// logged for every request it serves.  The entries are made through a
+// service context built by NewApexLogServiceContextWithHandler from
+// the provided handler, which will typically be an ApexLogNSQHandler
+// or AsyncApexLogNSQHandler.
+//
+// Each entry has the fields "method", "path", "status", "bytes",
+// "duration" and "user_agent", plus "request_id" when the request has
+// one, either from RequestContextMiddleware or the RequestIDHeader.
+// Entries are logged at error level for 5xx responses, warn level for
+// 4xx responses, and info level otherwise.
+func NewAccessLogMiddleware(handler log.Handler, next http.Handler, options AccessLogOptions) http.Handler {
+	if options.Message == "" {
+		options.Message = "request"
+	}
+	skipPaths := make(map[string]bool, len(options.SkipPaths))
+	for _, p := range options.SkipPaths {
+		skipPaths[p] = true
+	}
+	return &accessLogger{
+		ctx:       NewApexLogServiceContextWithHandler(handler),
+		next:      next,
+		options:   options,
+		skipPaths: skipPaths,
+	}
+}
+
+// sampled reports whether a successful request should be logged.
+func (a *accessLogger) sampled() bool {
+	if a.options.SampleSuccess <= 1 {
+		return true
+	}
+	return (atomic.AddUint64(&a.successes, 1)-1)%uint64(a.options.SampleSuccess) == 0
+}
+
+func (a *accessLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.skipPaths[r.URL.Path] || (a.options.Skip != nil && a.options.Skip(r)) {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+
+	start := time.Now()
+	recorder := &statusRecorder{ResponseWriter: w}
+	completed := false
+	// Deferred, so that a request whose handler panics is still
+	// logged, as a 500 unless a status was already written.  The
+	// panic carries on up to the server.
+	defer func() {
+		if !completed && recorder.status == 0 {
+			recorder.status = http.StatusInternalServerError
+		}
+		a.log(r, recorder, time.Since(start))
+	}()
+	a.next.ServeHTTP(recorder.wrap(), r)
+	completed = true
+}
+
+// log makes the entry for a request that has been served.
+func (a *accessLogger) log(r *http.Request, recorder *statusRecorder, duration time.Duration) {
+	status := recorder.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status >= 200 && status < 300 && !a.sampled() {
+		return
+	}
+
+	fields := log.Fields{
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"status":     strconv.Itoa(status),
+		"bytes":      strconv.Itoa(recorder.bytes),
+		"duration":   duration,
+		"user_agent": r.UserAgent(),
+	}
+	requestID := RequestIDFromContext(r.Context())
+	if requestID == "" {
+		requestID = r.Header.Get(RequestIDHeader)
+	}
+	if requestID != "" {
+		fields["request_id"] = requestID
+	}
+
+	entry := a.ctx.WithFields(fields)
+	switch {
+	case status >= 500:
+		entry.Error(a.options.Message)
+	case status >= 400:
+		entry.Warn(a.options.Message)
+	default:
+		entry.Info(a.options.Message)
+	}
+}
@@ -0,0 +1,145 @@
+package apexovernsq
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	mem := memory.New()
+	handler := NewAccessLogMiddleware(mem, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello"))
+	}), AccessLogOptions{SkipPaths: []string{"/health"}})
+
+	request := httptest.NewRequest("GET", "/orders", nil)
+	request.Header.Set("User-Agent", "test-agent")
+	request.Header.Set(RequestIDHeader, "abc-123")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/missing", nil))
+
+	if count := len(mem.Entries); count != 2 {
+		t.Fatalf("Expected 2 entries, got %d", count)
+	}
+	entry := mem.Entries[0]
+	expected := log.Fields{
+		"method":     "GET",
+		"path":       "/orders",
+		"status":     "200",
+		"bytes":      "5",
+		"user_agent": "test-agent",
+		"request_id": "abc-123",
+		"service":    expectedServiceName,
+	}
+	for name, value := range expected {
+		if entry.Fields.Get(name) != value {
+			t.Errorf("Expected %s=%q, got %s=%v", name, value, name, entry.Fields.Get(name))
+		}
+	}
+	if entry.Fields.Get("duration") == nil {
+		t.Error("Expected a duration field")
+	}
+	if entry.Level != log.InfoLevel || mem.Entries[1].Level != log.WarnLevel {
+		t.Errorf("Expected info then warn, got %s then %s", entry.Level, mem.Entries[1].Level)
+	}
+}
+
+func TestAccessLogMiddlewareSampling(t *testing.T) {
+	mem := memory.New()
+	handler := NewAccessLogMiddleware(mem, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}), AccessLogOptions{
+		SampleSuccess: 3,
+		Skip: func(r *http.Request) bool {
+			return r.Method == "OPTIONS"
+		},
+	})
+
+	for i := 0; i < 6; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("OPTIONS", "/ok", nil))
+
+	if count := len(mem.Entries); count != 3 {
+		t.Fatalf("Expected 2 sampled successes and 1 failure, got %d entries", count)
+	}
+	if mem.Entries[2].Level != log.ErrorLevel {
+		t.Errorf("Expected the failure to be logged at error level, got %s", mem.Entries[2].Level)
+	}
+}
+
+// hijackableRecorder is an httptest.ResponseRecorder that can also be
+// hijacked, like the ResponseWriter of an HTTP/1 server.
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	return nil, nil, nil
+}
+
+func TestAccessLogMiddlewareForwardsInterfaces(t *testing.T) {
+	mem := memory.New()
+	var flushes, hijacks, pushes bool
+	handler := NewAccessLogMiddleware(mem, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flushes = w.(http.Flusher)
+		_, pushes = w.(http.Pusher)
+		var hijacker http.Hijacker
+		if hijacker, hijacks = w.(http.Hijacker); hijacks {
+			hijacker.Hijack()
+		}
+	}), AccessLogOptions{})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream", nil))
+	if !flushes || hijacks || pushes {
+		t.Errorf("Expected only http.Flusher, got flusher=%t hijacker=%t pusher=%t", flushes, hijacks, pushes)
+	}
+
+	recorder := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/socket", nil))
+	if !flushes || !hijacks || pushes || !recorder.hijacked {
+		t.Errorf("Expected http.Flusher and http.Hijacker, got flusher=%t hijacker=%t pusher=%t", flushes, hijacks, pushes)
+	}
+	if status := mem.Entries[1].Fields.Get("status"); status != "101" {
+		t.Errorf("Expected a hijacked request to be logged as 101, got %v", status)
+	}
+}
+
+func TestAccessLogMiddlewarePanic(t *testing.T) {
+	mem := memory.New()
+	handler := NewAccessLogMiddleware(mem, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}), AccessLogOptions{})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Expected the panic to carry on")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/boom", nil))
+	}()
+
+	if count := len(mem.Entries); count != 1 {
+		t.Fatalf("Expected 1 entry, got %d", count)
+	}
+	if entry := mem.Entries[0]; entry.Level != log.ErrorLevel || entry.Fields.Get("status") != "500" {
+		t.Errorf("Expected an error with status 500, got %s %v", entry.Level, entry.Fields)
+	}
+}
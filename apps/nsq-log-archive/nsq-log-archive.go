@@ -0,0 +1,150 @@
+/*
+nsq-log-archive is a program that consumes log messages from a topic on one or more nsqd instances, using a durable channel, and archives them to compressed segment files partitioned by service and hour.
+
+Segments are written to <dir>/<service>/YYYY/MM/DD/HH.jsonl.gz, as gzipped JSON lines.  Messages are collected into batches, and each batch is appended to its segments and synced to disk before its messages are finished.  If the program stops, or crashes, before a batch is on disk its messages are requeued by nsqd and archived when it restarts.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.avct.io/apexovernsq"
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+type stringFlags []string
+
+func (n *stringFlags) Set(value string) error {
+	*n = append(*n, value)
+	return nil
+}
+
+func (n *stringFlags) String() string {
+	return strings.Join(*n, ",")
+}
+
+func listenToNSQ(consumer *nsq.Consumer, handler *apexovernsq.BatchNSQApexLogHandler, p *parameters) error {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	err := consumer.ConnectToNSQDs(p.nsqdTCPAddrs)
+	if err != nil {
+		return err
+	}
+
+	err = consumer.ConnectToNSQLookupds(p.lookupdHTTPAddrs)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-consumer.StopChan:
+			return nil
+		case <-sigChan:
+			// Write out the current batch while the
+			// connections are still open to finish its
+			// messages.
+			handler.Flush()
+			consumer.Stop()
+		}
+	}
+}
+
+func archiveFromNSQ(p *parameters) error {
+	// Every entry must reach the archive, whatever its level.
+	alog.SetLevel(alog.DebugLevel)
+
+	if err := os.MkdirAll(*p.dir, 0755); err != nil {
+		return err
+	}
+
+	cfg := nsq.NewConfig()
+	cfg.MaxInFlight = *p.batchSize
+	consumer, err := nsq.NewConsumer(*p.topic, *p.channel, cfg)
+	if err != nil {
+		return err
+	}
+	handler := apexovernsq.NewBatchNSQApexLogHandler(newSegmentWriter(*p.dir), protobuf.Unmarshal, *p.batchSize, *p.batchWait)
+	consumer.AddHandler(handler)
+
+	return listenToNSQ(consumer, handler, p)
+}
+
+type parameters struct {
+	topic            *string
+	channel          *string
+	dir              *string
+	batchSize        *int
+	batchWait        *time.Duration
+	nsqdTCPAddrs     stringFlags
+	lookupdHTTPAddrs stringFlags
+}
+
+func newParameters() *parameters {
+	p := &parameters{
+		topic:            flag.String("topic", "", "NSQ topic to consume from [Required]"),
+		channel:          flag.String("channel", "archive", "durable NSQ channel to consume from"),
+		dir:              flag.String("dir", "", "directory to write segments to [Required]"),
+		batchSize:        flag.Int("batch-size", 500, "maximum number of messages written to disk at once"),
+		batchWait:        flag.Duration("batch-wait", time.Second, "maximum time a message waits to be written to disk"),
+		nsqdTCPAddrs:     stringFlags{},
+		lookupdHTTPAddrs: stringFlags{},
+	}
+	flag.Var(&p.nsqdTCPAddrs, "nsqd-tcp-address", "nsqd TCP address (may be given multiple times)")
+	flag.Var(&p.lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
+
+	return p
+}
+
+func (p *parameters) check() error {
+	if *p.topic == "" {
+		return errors.New("Please provide a topic")
+	}
+	if *p.channel == "" {
+		return errors.New("Please provide a channel")
+	}
+	if strings.HasSuffix(*p.channel, "#ephemeral") {
+		return errors.New("the channel must be durable, not #ephemeral")
+	}
+	if *p.dir == "" {
+		return errors.New("Please provide a directory")
+	}
+	if *p.batchSize < 1 {
+		return errors.New("--batch-size must be at least 1")
+	}
+	if *p.batchWait <= 0 {
+		return errors.New("--batch-wait must be positive")
+	}
+
+	if len(p.nsqdTCPAddrs) == 0 && len(p.lookupdHTTPAddrs) == 0 {
+		return errors.New("--nsqd-tcp-address or --lookupd-http-address required")
+	}
+	if len(p.nsqdTCPAddrs) > 0 && len(p.lookupdHTTPAddrs) > 0 {
+		return errors.New("use --nsqd-tcp-address or --lookupd-http-address not both")
+	}
+	return nil
+}
+
+func main() {
+	p := newParameters()
+	flag.Parse()
+	if err := p.check(); err != nil {
+		flag.PrintDefaults()
+		log.Fatal(err)
+	}
+	err := archiveFromNSQ(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
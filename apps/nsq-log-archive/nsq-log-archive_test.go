@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	alog "github.com/apex/log"
+)
+
+func assertError(t *testing.T, err error, expected string) {
+	if err == nil {
+		t.Error("Expected error, nil returned")
+		return
+	}
+	msg := err.Error()
+	if msg != expected {
+		t.Errorf("Expected %q, got %q", expected, msg)
+	}
+}
+
+var testParameters = newParameters()
+
+func resetParameters() *parameters {
+	p := testParameters
+	*p.topic = ""
+	*p.channel = "archive"
+	*p.dir = ""
+	*p.batchSize = 500
+	*p.batchWait = time.Second
+	p.nsqdTCPAddrs = stringFlags{}
+	p.lookupdHTTPAddrs = stringFlags{}
+	return p
+}
+
+func TestCheckParameters(t *testing.T) {
+	p := resetParameters()
+	err := p.check()
+	assertError(t, err, "Please provide a topic")
+
+	*p.topic = "thisworks"
+	*p.channel = "archive#ephemeral"
+	err = p.check()
+	assertError(t, err, "the channel must be durable, not #ephemeral")
+
+	*p.channel = "archive"
+	err = p.check()
+	assertError(t, err, "Please provide a directory")
+
+	*p.dir = "/tmp"
+	*p.batchSize = 0
+	err = p.check()
+	assertError(t, err, "--batch-size must be at least 1")
+
+	*p.batchSize = 10
+	err = p.check()
+	assertError(t, err, "--nsqd-tcp-address or --lookupd-http-address required")
+
+	p.lookupdHTTPAddrs.Set("foo")
+	p.nsqdTCPAddrs.Set("bar")
+	err = p.check()
+	assertError(t, err, "use --nsqd-tcp-address or --lookupd-http-address not both")
+
+	p.lookupdHTTPAddrs = stringFlags{}
+	err = p.check()
+	if err != nil {
+		t.Errorf("Expect nil, but got an error: %q", err.Error())
+	}
+}
+
+func readSegment(t *testing.T, path string) []*alog.Entry {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []*alog.Entry
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		entry := &alog.Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// testEntry returns an entry from a service at the given time, with
+// any extra fields.  An empty service is left out.
+func testEntry(service string, level alog.Level, message string, timestamp time.Time, fields alog.Fields) *alog.Entry {
+	all := alog.Fields{}
+	if service != "" {
+		all["service"] = service
+	}
+	for name, value := range fields {
+		all[name] = value
+	}
+	return &alog.Entry{
+		Fields:    all,
+		Level:     level,
+		Message:   message,
+		Timestamp: timestamp,
+	}
+}
+
+func TestSegmentWriterPartitionsByServiceAndHour(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newSegmentWriter(dir)
+	hour := time.Date(2017, 6, 1, 9, 30, 0, 0, time.UTC)
+	err = w.HandleBatch([]*alog.Entry{
+		testEntry("billing", alog.InfoLevel, "one", hour, nil),
+		testEntry("auth", alog.InfoLevel, "two", hour, nil),
+		testEntry("billing", alog.InfoLevel, "three", hour.Add(time.Hour), nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.HandleBatch([]*alog.Entry{testEntry("billing", alog.InfoLevel, "four", hour, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readSegment(t, filepath.Join(dir, "billing", "2017", "06", "01", "09.jsonl.gz"))
+	if len(entries) != 2 || entries[0].Message != "one" || entries[1].Message != "four" {
+		t.Errorf("Unexpected billing entries for 09:00: %+v", entries)
+	}
+	entries = readSegment(t, filepath.Join(dir, "billing", "2017", "06", "01", "10.jsonl.gz"))
+	if len(entries) != 1 || entries[0].Message != "three" {
+		t.Errorf("Unexpected billing entries for 10:00: %+v", entries)
+	}
+	entries = readSegment(t, filepath.Join(dir, "auth", "2017", "06", "01", "09.jsonl.gz"))
+	if len(entries) != 1 || entries[0].Message != "two" {
+		t.Errorf("Unexpected auth entries: %+v", entries)
+	}
+}
+
+func TestSegmentWriterForgetsClosedSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newSegmentWriter(dir)
+	now := time.Date(2017, 6, 1, 9, 30, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	if err := w.HandleBatch([]*alog.Entry{testEntry("billing", alog.InfoLevel, "one", now, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(segmentIdle)
+	if err := w.HandleBatch([]*alog.Entry{testEntry("billing", alog.InfoLevel, "two", now, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if count := len(w.checked); count != 1 {
+		t.Errorf("Expected only the open segment to be remembered, got %d", count)
+	}
+	if _, ok := w.checked[filepath.Join(dir, "billing", "2017", "06", "01", "10.jsonl.gz")]; !ok {
+		t.Errorf("Expected the 10:00 segment to be remembered, got %v", w.checked)
+	}
+}
+
+func TestSegmentWriterUndoesFailedBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hour := time.Date(2017, 6, 1, 9, 30, 0, 0, time.UTC)
+	w := newSegmentWriter(dir)
+	if err := w.HandleBatch([]*alog.Entry{testEntry("auth", alog.InfoLevel, "one", hour, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	// A directory where the billing segment should be makes it
+	// impossible to write.
+	if err := os.MkdirAll(filepath.Join(dir, "billing", "2017", "06", "01", "09.jsonl.gz"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = w.HandleBatch([]*alog.Entry{
+		testEntry("auth", alog.InfoLevel, "two", hour, nil),
+		testEntry("billing", alog.InfoLevel, "three", hour, nil),
+	})
+	if err == nil {
+		t.Fatal("Expected the batch to fail")
+	}
+	entries := readSegment(t, filepath.Join(dir, "auth", "2017", "06", "01", "09.jsonl.gz"))
+	if len(entries) != 1 || entries[0].Message != "one" {
+		t.Errorf("Expected the failed batch to be undone, got %+v", entries)
+	}
+}
+
+func TestSegmentWriterRepairsTruncatedSegment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hour := time.Date(2017, 6, 1, 9, 30, 0, 0, time.UTC)
+	w := newSegmentWriter(dir)
+	if err := w.HandleBatch([]*alog.Entry{testEntry("billing", alog.InfoLevel, "one", hour, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "billing", "2017", "06", "01", "09.jsonl.gz")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash part way through writing a second batch.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMember(file, []*alog.Entry{testEntry("billing", alog.InfoLevel, "lost", hour, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if err := os.Truncate(path, info.Size()+10); err != nil {
+		t.Fatal(err)
+	}
+
+	w = newSegmentWriter(dir)
+	if err := w.HandleBatch([]*alog.Entry{testEntry("billing", alog.InfoLevel, "two", hour, nil)}); err != nil {
+		t.Fatal(err)
+	}
+	entries := readSegment(t, path)
+	if len(entries) != 2 || entries[0].Message != "one" || entries[1].Message != "two" {
+		t.Errorf("Unexpected entries after repair: %+v", entries)
+	}
+}
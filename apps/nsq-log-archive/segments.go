@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"code.avct.io/apexovernsq"
+	alog "github.com/apex/log"
+)
+
+// segmentIdle is how long a segment can go without being written to
+// before it's considered closed.
+const segmentIdle = time.Hour
+
+// segmentWriter appends batches of entries to gzipped JSON lines
+// segment files, one per service per hour, of the form
+//
+//	<dir>/<service>/YYYY/MM/DD/HH.jsonl.gz
+//
+// Each batch written to a segment becomes a separate gzip member,
+// which is synced to disk before the batch is reported as written.
+// Readers such as gzip -d and compress/gzip treat the concatenated
+// members as a single stream.
+//
+// Each segment is checked for incomplete data the first time it's
+// written to.  Once a segment has gone segmentIdle without being
+// written to it's considered closed and forgotten, so it will be
+// checked again if a late entry reopens it.
+type segmentWriter struct {
+	dir     string
+	checked map[string]time.Time
+	now     func() time.Time
+}
+
+func newSegmentWriter(dir string) *segmentWriter {
+	return &segmentWriter{
+		dir:     dir,
+		checked: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// segmentPath returns the path of the segment an entry belongs in.
+func (w *segmentWriter) segmentPath(e *alog.Entry) string {
+	timestamp := e.Timestamp
+	if timestamp.IsZero() {
+		timestamp = w.now()
+	}
+	return filepath.Join(w.dir, apexovernsq.ServiceDirName(e), timestamp.UTC().Format("2006/01/02/15")+".jsonl.gz")
+}
+
+// HandleBatch makes segmentWriter an apexovernsq.BatchHandler.  It
+// only returns nil once every entry in the batch is safely on disk,
+// so that the batch's messages can be finished.  If any segment can't
+// be written, every segment already written for the batch is
+// truncated back to its original length, as the whole batch will be
+// requeued.
+func (w *segmentWriter) HandleBatch(entries []*alog.Entry) error {
+	segments := make(map[string][]*alog.Entry)
+	for _, e := range entries {
+		path := w.segmentPath(e)
+		segments[path] = append(segments[path], e)
+	}
+	paths := make([]string, 0, len(segments))
+	for path := range segments {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	starts := make(map[string]int64, len(paths))
+	for _, path := range paths {
+		start, err := w.appendSegment(path, segments[path])
+		if err != nil {
+			for written, start := range starts {
+				if err := truncateSegment(written, start); err != nil {
+					alog.WithError(err).WithField("path", written).Error("Cannot undo a partly written batch, its entries will be archived twice")
+				}
+			}
+			return fmt.Errorf("cannot write segment %s: %s", path, err)
+		}
+		starts[path] = start
+	}
+	w.forgetClosed()
+	return nil
+}
+
+// forgetClosed forgets the segments that haven't been written to for
+// segmentIdle.
+func (w *segmentWriter) forgetClosed() {
+	now := w.now()
+	for path, written := range w.checked {
+		if now.Sub(written) >= segmentIdle {
+			delete(w.checked, path)
+		}
+	}
+}
+
+// appendSegment writes entries to the segment at path as a single
+// gzip member and syncs it, and returns the length the segment had
+// beforehand.  If anything goes wrong the segment is truncated back
+// to that length, so that a requeued batch isn't written twice.
+func (w *segmentWriter) appendSegment(path string, entries []*alog.Entry) (int64, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	if _, ok := w.checked[path]; !ok {
+		if err := repairSegment(path); err != nil {
+			return 0, err
+		}
+	}
+	w.checked[path] = w.now()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	start := info.Size()
+
+	err = writeMember(file, entries)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Truncate(start)
+		file.Close()
+		return start, err
+	}
+	if err := file.Close(); err != nil {
+		return start, err
+	}
+	if start == 0 {
+		// Make sure a newly created segment's directory entry
+		// survives a crash too.
+		return start, syncDir(dir)
+	}
+	return start, nil
+}
+
+// truncateSegment truncates the segment at path to size and syncs it.
+func truncateSegment(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// writeMember writes entries as JSON lines in one gzip member.
+func writeMember(out io.Writer, entries []*alog.Entry) error {
+	gz := gzip.NewWriter(out)
+	enc := json.NewEncoder(gz)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return gz.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// countingReader counts the bytes consumed from it.  It implements
+// io.ByteReader so that compress/gzip reads from it directly,
+// without buffering ahead, which keeps the count exact.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// validLength returns the length of the longest prefix of a segment
+// made up of complete gzip members.
+func validLength(r io.Reader) int64 {
+	cr := &countingReader{r: bufio.NewReader(r)}
+	var valid int64
+	var gz *gzip.Reader
+	for {
+		var err error
+		if gz == nil {
+			gz, err = gzip.NewReader(cr)
+		} else {
+			err = gz.Reset(cr)
+		}
+		if err != nil {
+			return valid
+		}
+		gz.Multistream(false)
+		if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+			return valid
+		}
+		valid = cr.n
+	}
+}
+
+// repairSegment truncates a segment to its last complete gzip member.
+// A segment only has an incomplete member if the process was killed
+// while writing to it, in which case the member's messages were never
+// finished and will be delivered again.
+func repairSegment(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	valid := validLength(file)
+	if valid == info.Size() {
+		return nil
+	}
+	alog.WithFields(alog.Fields{
+		"path":      path,
+		"size":      info.Size(),
+		"truncated": info.Size() - valid,
+	}).Warn("Truncating incomplete data from segment")
+	if err := file.Truncate(valid); err != nil {
+		return err
+	}
+	return file.Sync()
+}
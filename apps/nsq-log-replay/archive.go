@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+)
+
+const (
+	formatAuto     = "auto"
+	formatJSON     = "json"
+	formatProtobuf = "protobuf"
+)
+
+// maxRecordSize is the largest length-delimited protobuf record we
+// are prepared to read, to guard against reading a corrupt or
+// mistyped file as one enormous record.
+const maxRecordSize = 16 << 20
+
+// archiveFormat works out the format of an archive file from its
+// name.  Files ending in .pb or .protobuf, optionally followed by
+// .gz, are length-delimited protobuf; everything else is JSON lines.
+func archiveFormat(path, format string) string {
+	if format != formatAuto {
+		return format
+	}
+	name := strings.TrimSuffix(path, ".gz")
+	if strings.HasSuffix(name, ".pb") || strings.HasSuffix(name, ".protobuf") {
+		return formatProtobuf
+	}
+	return formatJSON
+}
+
+// isArchiveFile reports whether a file found while walking a
+// directory looks like an archive.
+func isArchiveFile(name string) bool {
+	name = strings.TrimSuffix(name, ".gz")
+	for _, ext := range []string{".jsonl", ".json", ".log", ".pb", ".protobuf"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPaths replaces any directories in paths with the archive
+// files beneath them, in lexical order.  With the layout written by
+// nsq-log-archive that puts each service's entries in time order.  A
+// path of "-", meaning standard input, is passed through as it is.
+func expandPaths(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if path == "-" {
+			expanded = append(expanded, path)
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && isArchiveFile(name) {
+				found = append(found, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		expanded = append(expanded, found...)
+	}
+	return expanded, nil
+}
+
+// maybeGunzip returns a reader that decompresses r if it starts with
+// the gzip magic number, and otherwise reads r as it is.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// readJSONLines passes each entry in a JSON lines archive to fn.
+func readJSONLines(r io.Reader, fn func(*alog.Entry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		entry := &alog.Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// readProtobufRecords passes each entry in a protobuf archive to fn.
+// Each record is a protobuf.Marshal encoded entry preceded by its
+// length as a varint.
+func readProtobufRecords(r io.Reader, fn func(*alog.Entry) error) error {
+	br := bufio.NewReader(r)
+	for record := 1; ; record++ {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %s", record, err)
+		}
+		if size > maxRecordSize {
+			return fmt.Errorf("record %d: size %d is too large", record, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return fmt.Errorf("record %d: %s", record, err)
+		}
+		entry := &alog.Entry{}
+		if err := protobuf.Unmarshal(data, entry); err != nil {
+			return fmt.Errorf("record %d: %s", record, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// readArchive passes each entry in r, which is in the given format and
+// may be gzipped, to fn.
+func readArchive(r io.Reader, format string, fn func(*alog.Entry) error) error {
+	r, err := maybeGunzip(r)
+	if err != nil {
+		return err
+	}
+	if format == formatProtobuf {
+		return readProtobufRecords(r, fn)
+	}
+	return readJSONLines(r, fn)
+}
+
+// readArchiveFile passes each entry in the archive file at path to
+// fn.  A path of "-" reads from standard input.
+func readArchiveFile(path, format string, fn func(*alog.Entry) error) error {
+	if path == "-" {
+		if format == formatAuto {
+			format = formatJSON
+		}
+		return readArchive(os.Stdin, format, fn)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := readArchive(file, archiveFormat(path, format), fn); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+/*
+nsq-log-replay is a program that reads archived log entries and publishes them back onto an NSQ topic, so that new consumers can be backfilled and incidents can be reproduced.
+
+It reads the files and directories given as arguments.  Archives may be JSON lines, as written by nsq-log-archive, or length-delimited protobuf records, where each entry encoded by protobuf.Marshal is preceded by its length as a varint.  Either may be gzipped.  Entries are republished, encoded with protobuf.Marshal, in the order they are read.  The entry_id, producer_id and seq fields stamped on entries by their original producer are removed, so that a DedupHandler doesn't drop replayed entries as duplicates and a GapDetector doesn't report them as restarts and gaps.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"code.avct.io/apexovernsq"
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+	"github.com/apex/log/handlers/logfmt"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// ReplayedField is the field added to republished entries when
+// --mark-replayed is given.
+const ReplayedField = "replayed"
+
+// producerFields are the fields stamped on entries by the producer
+// handlers.  They're removed from replayed entries, which would
+// otherwise be dropped as duplicates by a DedupHandler that still
+// remembers the originals, and reported as restarts and gaps by a
+// GapDetector.
+var producerFields = []string{
+	apexovernsq.EntryIDField,
+	apexovernsq.ProducerIDField,
+	apexovernsq.SequenceField,
+}
+
+type stringFlags []string
+
+func (n *stringFlags) Set(value string) error {
+	*n = append(*n, value)
+	return nil
+}
+
+func (n *stringFlags) String() string {
+	return strings.Join(*n, ",")
+}
+
+// rateLimitedHandler passes entries on no faster than the rate of its
+// ticker.
+type rateLimitedHandler struct {
+	handler alog.Handler
+	ticks   <-chan time.Time
+}
+
+func (h *rateLimitedHandler) HandleLog(e *alog.Entry) error {
+	<-h.ticks
+	return h.handler.HandleLog(e)
+}
+
+// inTimeRange reports whether an entry's Timestamp is within the
+// range given by the parameters.  Either end of the range may be
+// open.
+func (p *parameters) inTimeRange(e *alog.Entry) bool {
+	if !p.sinceTime.IsZero() && e.Timestamp.Before(p.sinceTime) {
+		return false
+	}
+	if !p.untilTime.IsZero() && !e.Timestamp.Before(p.untilTime) {
+		return false
+	}
+	return true
+}
+
+// replay reads every archive named by the parameters and passes the
+// selected entries to handler.  It returns the number of entries
+// read and the number passed on.
+func replay(p *parameters, handler alog.Handler) (read, replayed int, err error) {
+	if *p.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / *p.rate))
+		defer ticker.Stop()
+		handler = &rateLimitedHandler{handler: handler, ticks: ticker.C}
+	}
+	counter := alog.HandlerFunc(func(e *alog.Entry) error {
+		replayed++
+		return handler.HandleLog(e)
+	})
+	match, err := apexovernsq.MatchServiceFilter(apexovernsq.ServiceFilter{Services: p.services})
+	if err != nil {
+		return 0, 0, err
+	}
+	if *p.minLevel != "" {
+		level, err := alog.ParseLevel(*p.minLevel)
+		if err != nil {
+			return 0, 0, err
+		}
+		match = apexovernsq.MatchAll(match, apexovernsq.MatchMinLevel(level))
+	}
+	filter := apexovernsq.NewFilterHandler(counter, match)
+
+	paths, err := expandPaths(p.paths)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, path := range paths {
+		err = readArchiveFile(path, *p.format, func(e *alog.Entry) error {
+			read++
+			if !p.inTimeRange(e) {
+				return nil
+			}
+			for _, name := range producerFields {
+				delete(e.Fields, name)
+			}
+			if *p.markReplayed {
+				if e.Fields == nil {
+					e.Fields = alog.Fields{}
+				}
+				e.Fields[ReplayedField] = "true"
+			}
+			return filter.HandleLog(e)
+		})
+		if err != nil {
+			return read, replayed, err
+		}
+	}
+	return read, replayed, nil
+}
+
+// makePublishHandler returns a handler that publishes each entry,
+// unchanged apart from its encoding, to the topic.  Entries are
+// published directly rather than through an ApexLogNSQHandler, which
+// would add the replaying process's own service fields, entry IDs and
+// sequence numbers.
+func makePublishHandler(producer *nsq.Producer, topic string) alog.Handler {
+	return alog.HandlerFunc(func(e *alog.Entry) error {
+		body, err := protobuf.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return producer.Publish(topic, body)
+	})
+}
+
+func replayToNSQ(p *parameters) error {
+	var handler alog.Handler
+	if *p.dryRun {
+		handler = logfmt.New(os.Stdout)
+	} else {
+		producer, err := nsq.NewProducer(*p.nsqdTCPAddr, nsq.NewConfig())
+		if err != nil {
+			return err
+		}
+		defer producer.Stop()
+		handler = makePublishHandler(producer, *p.topic)
+	}
+
+	read, replayed, err := replay(p, handler)
+	log.Printf("Replayed %d of %d entries read", replayed, read)
+	return err
+}
+
+type parameters struct {
+	topic        *string
+	nsqdTCPAddr  *string
+	format       *string
+	since        *string
+	until        *string
+	minLevel     *string
+	rate         *float64
+	markReplayed *bool
+	dryRun       *bool
+	services     stringFlags
+	paths        []string
+	sinceTime    time.Time
+	untilTime    time.Time
+}
+
+func newParameters() *parameters {
+	p := &parameters{
+		topic:        flag.String("topic", "", "NSQ topic to publish to [Required unless --dry-run]"),
+		nsqdTCPAddr:  flag.String("nsqd-tcp-address", "", "nsqd TCP address to publish to [Required unless --dry-run]"),
+		format:       flag.String("format", formatAuto, "archive format: auto, json or protobuf. auto picks protobuf for files ending .pb or .protobuf, and json otherwise"),
+		since:        flag.String("since", "", "only replay entries at or after this RFC 3339 time"),
+		until:        flag.String("until", "", "only replay entries before this RFC 3339 time"),
+		minLevel:     flag.String("min-level", "", "only replay entries at or above this level"),
+		rate:         flag.Float64("rate", 0, "maximum entries published per second, 0 for no limit"),
+		markReplayed: flag.Bool("mark-replayed", false, "add a \""+ReplayedField+"\" field to every replayed entry. Replayed entries always lose their entry_id, producer_id and seq fields, so that deduplication and gap detection leave them alone"),
+		dryRun:       flag.Bool("dry-run", false, "write the selected entries to stdout instead of publishing them"),
+		services:     stringFlags{},
+	}
+	flag.Var(&p.services, "service", "service to replay logs for, as a name, glob or \"re:\" prefixed regular expression (may be given multiple times). If no service flag is specified, logs for all services will be replayed")
+
+	return p
+}
+
+func (p *parameters) check() error {
+	if !*p.dryRun {
+		if *p.topic == "" {
+			return errors.New("Please provide a topic")
+		}
+		if *p.nsqdTCPAddr == "" {
+			return errors.New("--nsqd-tcp-address required")
+		}
+	}
+	if len(p.paths) == 0 {
+		return errors.New("Please provide one or more archive files or directories")
+	}
+	switch *p.format {
+	case formatAuto, formatJSON, formatProtobuf:
+	default:
+		return fmt.Errorf("unknown format %q", *p.format)
+	}
+	if *p.minLevel != "" {
+		if _, err := alog.ParseLevel(*p.minLevel); err != nil {
+			return fmt.Errorf("unknown level %q", *p.minLevel)
+		}
+	}
+	if *p.rate < 0 {
+		return errors.New("--rate must not be negative")
+	}
+
+	var err error
+	if *p.since != "" {
+		if p.sinceTime, err = time.Parse(time.RFC3339, *p.since); err != nil {
+			return fmt.Errorf("invalid --since time: %s", err)
+		}
+	}
+	if *p.until != "" {
+		if p.untilTime, err = time.Parse(time.RFC3339, *p.until); err != nil {
+			return fmt.Errorf("invalid --until time: %s", err)
+		}
+	}
+	if !p.sinceTime.IsZero() && !p.untilTime.IsZero() && !p.sinceTime.Before(p.untilTime) {
+		return errors.New("--since must be before --until")
+	}
+	return nil
+}
+
+func main() {
+	p := newParameters()
+	flag.Parse()
+	p.paths = flag.Args()
+	if err := p.check(); err != nil {
+		flag.PrintDefaults()
+		log.Fatal(err)
+	}
+	err := replayToNSQ(p)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.avct.io/apexovernsq"
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func assertError(t *testing.T, err error, expected string) {
+	if err == nil {
+		t.Error("Expected error, nil returned")
+		return
+	}
+	msg := err.Error()
+	if msg != expected {
+		t.Errorf("Expected %q, got %q", expected, msg)
+	}
+}
+
+var testParameters = newParameters()
+
+func resetParameters() *parameters {
+	p := testParameters
+	*p.topic = "log"
+	*p.nsqdTCPAddr = "localhost:4150"
+	*p.format = formatAuto
+	*p.since = ""
+	*p.until = ""
+	*p.minLevel = ""
+	*p.rate = 0
+	*p.markReplayed = false
+	*p.dryRun = false
+	p.services = stringFlags{}
+	p.paths = []string{"archive"}
+	p.sinceTime = time.Time{}
+	p.untilTime = time.Time{}
+	return p
+}
+
+func TestCheckParameters(t *testing.T) {
+	p := resetParameters()
+	*p.topic = ""
+	assertError(t, p.check(), "Please provide a topic")
+
+	*p.dryRun = true
+	if err := p.check(); err != nil {
+		t.Errorf("Expect nil, but got an error: %q", err.Error())
+	}
+
+	*p.dryRun = false
+	*p.topic = "log"
+	*p.nsqdTCPAddr = ""
+	assertError(t, p.check(), "--nsqd-tcp-address required")
+
+	p = resetParameters()
+	p.paths = nil
+	assertError(t, p.check(), "Please provide one or more archive files or directories")
+
+	p = resetParameters()
+	*p.format = "xml"
+	assertError(t, p.check(), `unknown format "xml"`)
+
+	p = resetParameters()
+	*p.minLevel = "loud"
+	assertError(t, p.check(), `unknown level "loud"`)
+
+	p = resetParameters()
+	*p.since = "2017-06-02T00:00:00Z"
+	*p.until = "2017-06-01T00:00:00Z"
+	assertError(t, p.check(), "--since must be before --until")
+
+	p = resetParameters()
+	*p.since = "yesterday"
+	if err := p.check(); err == nil {
+		t.Error("Expected an error for an invalid --since time")
+	}
+
+	p = resetParameters()
+	if err := p.check(); err != nil {
+		t.Errorf("Expect nil, but got an error: %q", err.Error())
+	}
+}
+
+// testEntry returns an entry from a service at the given time, with
+// any extra fields.  An empty service is left out.
+func testEntry(service string, level alog.Level, message string, timestamp time.Time, fields alog.Fields) *alog.Entry {
+	all := alog.Fields{}
+	if service != "" {
+		all["service"] = service
+	}
+	for name, value := range fields {
+		all[name] = value
+	}
+	return &alog.Entry{
+		Fields:    all,
+		Level:     level,
+		Message:   message,
+		Timestamp: timestamp,
+	}
+}
+
+// producedEntry returns an entry carrying the fields a producer stamps
+// on it, which replay is expected to remove.
+func producedEntry(service string, level alog.Level, message string, timestamp time.Time) *alog.Entry {
+	return testEntry(service, level, message, timestamp, alog.Fields{
+		apexovernsq.EntryIDField:    message,
+		apexovernsq.ProducerIDField: "web1/100/aa",
+		apexovernsq.SequenceField:   "1",
+	})
+}
+
+func writeJSONArchive(t *testing.T, path string, entries ...*alog.Entry) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gz.Close()
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeProtobufArchive(t *testing.T, path string, entries ...*alog.Entry) {
+	var buf bytes.Buffer
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, e := range entries {
+		data, err := protobuf.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(size[:binary.PutUvarint(size, uint64(len(data)))])
+		buf.Write(data)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayFiltersEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)
+	os.MkdirAll(filepath.Join(dir, "billing", "2017"), 0755)
+	writeJSONArchive(t, filepath.Join(dir, "billing", "2017", "09.jsonl.gz"),
+		producedEntry("billing", alog.InfoLevel, "too early", start.Add(-time.Minute)),
+		producedEntry("billing", alog.InfoLevel, "one", start),
+		producedEntry("billing", alog.DebugLevel, "too quiet", start.Add(time.Minute)),
+		producedEntry("billing", alog.ErrorLevel, "two", start.Add(2*time.Minute)),
+		producedEntry("billing", alog.InfoLevel, "too late", start.Add(time.Hour)),
+	)
+	writeProtobufArchive(t, filepath.Join(dir, "other.pb"),
+		producedEntry("auth", alog.InfoLevel, "wrong service", start),
+		producedEntry("billing-eu", alog.WarnLevel, "three", start.Add(3*time.Minute)),
+	)
+
+	p := resetParameters()
+	p.paths = []string{dir}
+	*p.since = "2017-06-01T09:00:00Z"
+	*p.until = "2017-06-01T10:00:00Z"
+	*p.minLevel = "info"
+	*p.markReplayed = true
+	p.services.Set("billing*")
+	if err := p.check(); err != nil {
+		t.Fatal(err)
+	}
+
+	mem := memory.New()
+	read, replayed, err := replay(p, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 7 || replayed != 3 {
+		t.Errorf("Expected 3 of 7 entries to be replayed, got %d of %d", replayed, read)
+	}
+	var messages []string
+	for _, e := range mem.Entries {
+		messages = append(messages, e.Message)
+		if e.Fields.Get(ReplayedField) != "true" {
+			t.Errorf("Expected entry %q to be marked as replayed", e.Message)
+		}
+		for _, name := range producerFields {
+			if _, ok := e.Fields[name]; ok {
+				t.Errorf("Expected %s to be removed from entry %q", name, e.Message)
+			}
+		}
+	}
+	if len(messages) != 3 || messages[0] != "one" || messages[1] != "two" || messages[2] != "three" {
+		t.Errorf("Unexpected entries replayed: %q", messages)
+	}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "billing"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "billing", "10.jsonl.gz"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "billing", "09.jsonl.gz"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "billing", "notes.txt"), nil, 0644)
+
+	paths, err := expandPaths([]string{"-", dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-", filepath.Join(dir, "billing", "09.jsonl.gz"), filepath.Join(dir, "billing", "10.jsonl.gz")}
+	if strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf("Expected %q, got %q", expected, paths)
+	}
+
+	_, err = expandPaths([]string{filepath.Join(dir, "missing")})
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected a missing path to be an error, got %v", err)
+	}
+}
+
+func TestReadProtobufRecordsRejectsTruncatedRecord(t *testing.T) {
+	data, _ := protobuf.Marshal(producedEntry("billing", alog.InfoLevel, "one", time.Now()))
+	size := make([]byte, binary.MaxVarintLen64)
+	archive := append(size[:binary.PutUvarint(size, uint64(len(data)))], data[:len(data)-1]...)
+
+	err := readArchive(bytes.NewReader(archive), formatProtobuf, func(*alog.Entry) error { return nil })
+	assertError(t, err, "record 1: unexpected EOF")
+}
+
+func TestArchiveFormat(t *testing.T) {
+	cases := map[string]string{
+		"09.jsonl.gz":   formatJSON,
+		"09.jsonl":      formatJSON,
+		"dump.pb":       formatProtobuf,
+		"dump.pb.gz":    formatProtobuf,
+		"dump.protobuf": formatProtobuf,
+	}
+	for path, expected := range cases {
+		if format := archiveFormat(path, formatAuto); format != expected {
+			t.Errorf("archiveFormat(%q): expected %s, got %s", path, expected, format)
+		}
+	}
+	if format := archiveFormat("dump.pb", formatJSON); format != formatJSON {
+		t.Errorf("Expected an explicit format to win, got %s", format)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpURL turns an nsqd or nsqlookupd HTTP address, which may or may
+// not include a scheme, into a URL for path with the given query.
+func httpURL(address, path string, query url.Values) string {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	return strings.TrimRight(address, "/") + path + "?" + query.Encode()
+}
+
+// getJSON fetches a URL from nsqd or nsqlookupd and decodes its JSON
+// response into v.  Older versions of NSQ wrap responses in an
+// envelope, with the real response in "data", and newer ones don't, so
+// both are handled.
+func getJSON(client *http.Client, address string, v interface{}) error {
+	resp, err := client.Get(address)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", address, resp.Status)
+	}
+
+	var envelope struct {
+		StatusCode int             `json:"status_code"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.StatusCode != 0 && envelope.Data != nil {
+		body = envelope.Data
+	}
+	return json.Unmarshal(body, v)
+}
+
+// lookupNSQDHTTPAddrs asks nsqlookupd for the HTTP addresses of the
+// nsqd instances that have a topic.
+func lookupNSQDHTTPAddrs(client *http.Client, lookupdHTTPAddr, topic string) ([]string, error) {
+	var lookup struct {
+		Producers []struct {
+			BroadcastAddress string `json:"broadcast_address"`
+			HTTPPort         int    `json:"http_port"`
+		} `json:"producers"`
+	}
+	err := getJSON(client, httpURL(lookupdHTTPAddr, "/lookup", url.Values{"topic": {topic}}), &lookup)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(lookup.Producers))
+	for _, producer := range lookup.Producers {
+		addrs = append(addrs, net.JoinHostPort(producer.BroadcastAddress, strconv.Itoa(producer.HTTPPort)))
+	}
+	return addrs, nil
+}
+
+// channelDepth asks nsqd how many messages are waiting on a channel.
+// A channel that doesn't exist yet has nothing waiting.
+func channelDepth(client *http.Client, nsqdHTTPAddr, topic, channel string) (int64, error) {
+	var stats struct {
+		Topics []struct {
+			TopicName string `json:"topic_name"`
+			Channels  []struct {
+				ChannelName string `json:"channel_name"`
+				Depth       int64  `json:"depth"`
+			} `json:"channels"`
+		} `json:"topics"`
+	}
+	query := url.Values{"format": {"json"}, "topic": {topic}, "channel": {channel}}
+	if err := getJSON(client, httpURL(nsqdHTTPAddr, "/stats", query), &stats); err != nil {
+		return 0, err
+	}
+	var depth int64
+	for _, t := range stats.Topics {
+		if t.TopicName != topic {
+			continue
+		}
+		for _, c := range t.Channels {
+			if c.ChannelName == channel {
+				depth += c.Depth
+			}
+		}
+	}
+	return depth, nil
+}
+
+// backlog returns the number of messages waiting on a channel of a
+// topic across every nsqd that can be found, either from nsqlookupd
+// or from the --nsqd-http-address flags.  It's an error if neither
+// was given, as there's then no way to check.
+func backlog(p *parameters, topic, channel string) (int64, error) {
+	if len(p.nsqdHTTPAddrs) == 0 && len(p.lookupdHTTPAddrs) == 0 {
+		return 0, errors.New("no nsqd HTTP address to ask, use --nsqd-http-address with --nsqd-tcp-address")
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	nsqdHTTPAddrs := append([]string{}, p.nsqdHTTPAddrs...)
+	for _, lookupdHTTPAddr := range p.lookupdHTTPAddrs {
+		addrs, err := lookupNSQDHTTPAddrs(client, lookupdHTTPAddr, topic)
+		if err != nil {
+			return 0, err
+		}
+		nsqdHTTPAddrs = append(nsqdHTTPAddrs, addrs...)
+	}
+
+	var total int64
+	seen := make(map[string]bool)
+	for _, addr := range nsqdHTTPAddrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		depth, err := channelDepth(client, addr, topic, channel)
+		if err != nil {
+			return 0, err
+		}
+		total += depth
+	}
+	return total, nil
+}
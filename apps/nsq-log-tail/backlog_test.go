@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResumeChannelName(t *testing.T) {
+	name := resumeChannelName(`CORP\tealeg`, "log")
+	if name != "tail-CORP_tealeg-log" {
+		t.Errorf("Unexpected channel name %q", name)
+	}
+	if name != resumeChannelName(`CORP\tealeg`, "log") {
+		t.Error("Expected the channel name to be stable")
+	}
+	long := resumeChannelName("tealeg", strings.Repeat("x", 100))
+	if len(long) != 64 {
+		t.Errorf("Expected a long channel name to be truncated to 64 characters, got %d", len(long))
+	}
+}
+
+func TestChannelName(t *testing.T) {
+	p := resetParameters()
+	*p.channel = "mine"
+	if channel := p.channelName("log"); channel != "mine" {
+		t.Errorf("Expected channel mine, got %q", channel)
+	}
+
+	*p.channel = ""
+	if channel := p.channelName("log"); !strings.HasSuffix(channel, "#ephemeral") {
+		t.Errorf("Expected an ephemeral channel, got %q", channel)
+	}
+}
+
+func newFakeNSQD(wrapped bool, depth int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" || r.URL.Query().Get("format") != "json" {
+			http.NotFound(w, r)
+			return
+		}
+		stats := fmt.Sprintf(`{"version":"1.0.0","topics":[
+			{"topic_name":"log","channels":[
+				{"channel_name":"mine","depth":%d},
+				{"channel_name":"other","depth":1000}]},
+			{"topic_name":"other","channels":[{"channel_name":"mine","depth":1000}]}]}`, depth)
+		if wrapped {
+			stats = `{"status_code":200,"status_txt":"OK","data":` + stats + `}`
+		}
+		fmt.Fprint(w, stats)
+	}))
+}
+
+func TestBacklog(t *testing.T) {
+	wrapped := newFakeNSQD(true, 3)
+	defer wrapped.Close()
+	unwrapped := newFakeNSQD(false, 4)
+	defer unwrapped.Close()
+
+	p := resetParameters()
+	p.nsqdHTTPAddrs = stringFlags{wrapped.URL, strings.TrimPrefix(unwrapped.URL, "http://")}
+	depth, err := backlog(p, "log", "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth != 7 {
+		t.Errorf("Expected a backlog of 7, got %d", depth)
+	}
+}
+
+func TestBacklogWithoutHTTPAddress(t *testing.T) {
+	p := resetParameters()
+	p.nsqdTCPAddrs = stringFlags{"localhost:4150"}
+	_, err := backlog(p, "log", "mine")
+	assertError(t, err, "no nsqd HTTP address to ask, use --nsqd-http-address with --nsqd-tcp-address")
+}
+
+func TestBacklogViaLookupd(t *testing.T) {
+	nsqd := newFakeNSQD(false, 5)
+	defer nsqd.Close()
+	host, port := "127.0.0.1", strings.TrimPrefix(nsqd.URL, "http://127.0.0.1:")
+
+	lookupd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/lookup" || r.URL.Query().Get("topic") != "log" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"channels":["mine"],"producers":[{"broadcast_address":%q,"tcp_port":4150,"http_port":%s}]}`, host, port)
+	}))
+	defer lookupd.Close()
+
+	p := resetParameters()
+	p.lookupdHTTPAddrs = stringFlags{lookupd.URL}
+	depth, err := backlog(p, "log", "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth != 5 {
+		t.Errorf("Expected a backlog of 5, got %d", depth)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+
+	alog "github.com/apex/log"
+)
+
+// The exit codes nsq-log-tail uses.
+const (
+	// exitOK means the tail ended normally, or that --until-match
+	// found a match.
+	exitOK = 0
+	// exitError means something went wrong.
+	exitError = 1
+	// exitNoMatch means that --until-match was given but the tail
+	// ended, because of --count or --timeout, without a match.
+	exitNoMatch = 2
+)
+
+// errTailDone is returned for entries that arrive once the tail has
+// ended, so that their messages are requeued rather than finished.
+var errTailDone = errors.New("the tail has ended")
+
+// boundedHandler passes entries on until --count of them have been
+// output, or until one matches --until-match, and then closes its
+// done channel.  Entries arriving after that aren't output, so that
+// no more than --count are ever output, and errTailDone is returned
+// for them so that another consumer of the channel can have them.
+type boundedHandler struct {
+	mu      sync.Mutex
+	handler alog.Handler
+	limit   int
+	match   *regexp.Regexp
+	count   int
+	matched bool
+	done    chan bool
+}
+
+// newBoundedHandler returns a boundedHandler that stops after limit
+// entries, unless limit is zero, or after an entry whose message
+// matches match, unless match is nil.
+func newBoundedHandler(handler alog.Handler, limit int, match *regexp.Regexp) *boundedHandler {
+	return &boundedHandler{
+		handler: handler,
+		limit:   limit,
+		match:   match,
+		done:    make(chan bool),
+	}
+}
+
+func (h *boundedHandler) finished() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *boundedHandler) HandleLog(e *alog.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finished() {
+		return errTailDone
+	}
+
+	h.count++
+	err := h.handler.HandleLog(e)
+	if h.match != nil && h.match.MatchString(e.Message) {
+		h.matched = true
+		close(h.done)
+	} else if h.limit > 0 && h.count >= h.limit {
+		close(h.done)
+	}
+	return err
+}
+
+// Done returns a channel that is closed once the tail should end.
+func (h *boundedHandler) Done() <-chan bool {
+	return h.done
+}
+
+// exitCode returns the code nsq-log-tail should exit with, now that
+// the tail has ended.
+func (h *boundedHandler) exitCode() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.match != nil && !h.matched {
+		return exitNoMatch
+	}
+	return exitOK
+}
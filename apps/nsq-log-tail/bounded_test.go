@@ -0,0 +1,88 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"code.avct.io/apexovernsq"
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+func TestBoundedHandlerCount(t *testing.T) {
+	mem := memory.New()
+	h := newBoundedHandler(mem, 2, nil)
+	for _, message := range []string{"one", "two", "three"} {
+		h.HandleLog(&alog.Entry{Message: message})
+	}
+	if len(mem.Entries) != 2 {
+		t.Errorf("Expected 2 entries to be output, got %d", len(mem.Entries))
+	}
+	select {
+	case <-h.Done():
+	default:
+		t.Error("Expected the tail to be done")
+	}
+	if code := h.exitCode(); code != exitOK {
+		t.Errorf("Expected exit code %d, got %d", exitOK, code)
+	}
+}
+
+func TestBoundedHandlerRequeuesAfterBound(t *testing.T) {
+	h := newBoundedHandler(memory.New(), 1, nil)
+	nsqHandler := apexovernsq.NewNSQApexLogHandler(h, protobuf.Unmarshal)
+	for i, message := range []string{"one", "two"} {
+		body, err := protobuf.Marshal(&alog.Entry{Level: alog.InfoLevel, Message: message})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var id nsq.MessageID
+		id[0] = byte(i)
+		err = nsqHandler.HandleMessage(nsq.NewMessage(id, body))
+		if message == "one" && err != nil {
+			t.Errorf("Expected the first message to be finished, got %s", err)
+		}
+		if message == "two" && err != errTailDone {
+			t.Errorf("Expected the second message to be requeued, got %v", err)
+		}
+	}
+}
+
+func TestBoundedHandlerUntilMatch(t *testing.T) {
+	mem := memory.New()
+	h := newBoundedHandler(mem, 0, regexp.MustCompile("ready"))
+	h.HandleLog(&alog.Entry{Message: "starting"})
+	if code := h.exitCode(); code != exitNoMatch {
+		t.Errorf("Expected exit code %d before a match, got %d", exitNoMatch, code)
+	}
+	h.HandleLog(&alog.Entry{Message: "version 2 ready"})
+	h.HandleLog(&alog.Entry{Message: "serving"})
+
+	if len(mem.Entries) != 2 || mem.Entries[1].Message != "version 2 ready" {
+		t.Errorf("Expected output to stop at the match, got %+v", mem.Entries)
+	}
+	select {
+	case <-h.Done():
+	default:
+		t.Error("Expected the tail to be done")
+	}
+	if code := h.exitCode(); code != exitOK {
+		t.Errorf("Expected exit code %d, got %d", exitOK, code)
+	}
+}
+
+func TestBoundedHandlerCountWithoutMatch(t *testing.T) {
+	h := newBoundedHandler(memory.New(), 1, regexp.MustCompile("ready"))
+	h.HandleLog(&alog.Entry{Message: "starting"})
+	select {
+	case <-h.Done():
+	default:
+		t.Error("Expected the tail to be done")
+	}
+	if code := h.exitCode(); code != exitNoMatch {
+		t.Errorf("Expected exit code %d, got %d", exitNoMatch, code)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// authSecretEnv is the environment variable the auth secret is read
+// from when neither --auth-secret nor --auth-secret-file is given, so
+// that it needn't appear on the command line.
+const authSecretEnv = "NSQ_AUTH_SECRET"
+
+// authSecretValue returns the auth secret from --auth-secret,
+// --auth-secret-file or the environment, in that order of preference,
+// or an empty string if there isn't one.
+func (p *parameters) authSecretValue() (string, error) {
+	if *p.authSecret != "" && *p.authSecretFile != "" {
+		return "", fmt.Errorf("use --auth-secret or --auth-secret-file not both")
+	}
+	if *p.authSecret != "" {
+		return *p.authSecret, nil
+	}
+	if *p.authSecretFile != "" {
+		secret, err := ioutil.ReadFile(*p.authSecretFile)
+		if err != nil {
+			return "", fmt.Errorf("cannot read --auth-secret-file: %s", err)
+		}
+		return strings.TrimSpace(string(secret)), nil
+	}
+	return os.Getenv(authSecretEnv), nil
+}
+
+// applyConnectionFlags applies the TLS, auth and tuning flags to the
+// consumer config, on top of any --consumer-opt flags, and validates
+// the result.  Flags that weren't given leave the config alone.
+func (p *parameters) applyConnectionFlags() error {
+	if (*p.tlsCert == "") != (*p.tlsKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be given together")
+	}
+	if *p.maxInFlight < 0 {
+		return fmt.Errorf("--max-in-flight must not be negative")
+	}
+	authSecret, err := p.authSecretValue()
+	if err != nil {
+		return err
+	}
+	options := []struct {
+		name  string
+		given bool
+		value interface{}
+	}{
+		{"tls_v1", *p.tls || *p.tlsRootCAFile != "" || *p.tlsCert != "" || *p.tlsInsecureSkipVerify, true},
+		{"tls_root_ca_file", *p.tlsRootCAFile != "", *p.tlsRootCAFile},
+		{"tls_cert", *p.tlsCert != "", *p.tlsCert},
+		{"tls_key", *p.tlsKey != "", *p.tlsKey},
+		{"tls_insecure_skip_verify", *p.tlsInsecureSkipVerify, true},
+		{"auth_secret", authSecret != "", authSecret},
+		{"max_in_flight", *p.maxInFlight > 0, *p.maxInFlight},
+	}
+	for _, option := range options {
+		if !option.given {
+			continue
+		}
+		if err := p.consumerConfig.Set(option.name, option.value); err != nil {
+			return fmt.Errorf("cannot set %s: %s", option.name, err)
+		}
+	}
+	return p.consumerConfig.Validate()
+}
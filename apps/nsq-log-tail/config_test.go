@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckAppliesConnectionFlags(t *testing.T) {
+	p := resetParameters()
+	p.topics.Set("thisworks")
+	p.nsqdTCPAddrs = stringFlags{"bar"}
+
+	p.consumerOpts.Set("max_in_flight=5")
+	*p.maxInFlight = 0
+	*p.authSecret = "sekrit"
+	*p.tlsInsecureSkipVerify = true
+	if err := p.check(); err != nil {
+		t.Fatalf("Expect nil, but got an error: %q", err.Error())
+	}
+	cfg := p.consumerConfig
+	if cfg.MaxInFlight != 5 || cfg.AuthSecret != "sekrit" || !cfg.TlsV1 || cfg.TlsConfig == nil || !cfg.TlsConfig.InsecureSkipVerify {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+
+	*p.maxInFlight = 50
+	if err := p.check(); err != nil {
+		t.Fatalf("Expect nil, but got an error: %q", err.Error())
+	}
+	if cfg.MaxInFlight != 50 {
+		t.Errorf("Expected --max-in-flight to override --consumer-opt, got %d", cfg.MaxInFlight)
+	}
+}
+
+func TestAuthSecretSources(t *testing.T) {
+	saved, wasSet := os.LookupEnv(authSecretEnv)
+	defer func() {
+		if wasSet {
+			os.Setenv(authSecretEnv, saved)
+		} else {
+			os.Unsetenv(authSecretEnv)
+		}
+	}()
+	os.Setenv(authSecretEnv, "from-env")
+
+	file, err := ioutil.TempFile("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-file\n")
+	file.Close()
+
+	p := resetParameters()
+	if secret, _ := p.authSecretValue(); secret != "from-env" {
+		t.Errorf("Expected the secret from the environment, got %q", secret)
+	}
+	*p.authSecretFile = file.Name()
+	if secret, _ := p.authSecretValue(); secret != "from-file" {
+		t.Errorf("Expected the secret from the file, got %q", secret)
+	}
+	*p.authSecret = "from-flag"
+	_, err = p.authSecretValue()
+	assertError(t, err, "use --auth-secret or --auth-secret-file not both")
+
+	*p.authSecretFile = ""
+	if secret, _ := p.authSecretValue(); secret != "from-flag" {
+		t.Errorf("Expected the secret from the flag, got %q", secret)
+	}
+}
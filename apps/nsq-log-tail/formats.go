@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	alog "github.com/apex/log"
+	"github.com/apex/log/handlers/cli"
+	"github.com/apex/log/handlers/logfmt"
+)
+
+// The output formats accepted by --format.
+const (
+	formatLogfmt   = "logfmt"
+	formatCLI      = "cli"
+	formatJSON     = "json"
+	formatTemplate = "template"
+	formatPretty   = "pretty"
+	formatTerminal = "terminal"
+)
+
+var formats = []string{formatLogfmt, formatCLI, formatJSON, formatTemplate, formatPretty, formatTerminal}
+
+// jsonHandler writes each entry as a JSON object on its own line.
+type jsonHandler struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONHandler(out io.Writer) *jsonHandler {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	return &jsonHandler{enc: enc}
+}
+
+func (h *jsonHandler) HandleLog(e *alog.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.enc.Encode(e)
+}
+
+// templateData is what a --template is executed against.
+type templateData struct {
+	Level   string
+	Time    time.Time
+	Message string
+	Fields  alog.Fields
+}
+
+// templateHandler writes each entry by executing a text/template.
+type templateHandler struct {
+	mu   sync.Mutex
+	out  io.Writer
+	tmpl *template.Template
+}
+
+// parseTemplate parses a --template.  A newline is added to the end
+// of the template if it doesn't already have one, so that each entry
+// is output on its own line.
+func parseTemplate(text string) (*template.Template, error) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return template.New("entry").Option("missingkey=zero").Parse(text)
+}
+
+func newTemplateHandler(out io.Writer, tmpl *template.Template) *templateHandler {
+	return &templateHandler{out: out, tmpl: tmpl}
+}
+
+func (h *templateHandler) HandleLog(e *alog.Entry) error {
+	data := templateData{
+		Level:   e.Level.String(),
+		Time:    e.Timestamp,
+		Message: e.Message,
+		Fields:  e.Fields,
+	}
+	var buf bytes.Buffer
+	if err := h.tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
+}
+
+// prettyHandler writes each entry over several lines: the time, level
+// and message first, followed by each field on its own line.  Field
+// values that span several lines, such as stack traces, and values
+// that are JSON, are expanded and indented.
+type prettyHandler struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newPrettyHandler(out io.Writer) *prettyHandler {
+	return &prettyHandler{out: out}
+}
+
+// prettyValue returns a field value ready for output by the
+// prettyHandler, as a list of lines.
+func prettyValue(value interface{}) []string {
+	text := fmt.Sprint(value)
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, []byte(trimmed), "", "  "); err == nil {
+			text = buf.String()
+		}
+	}
+	return strings.Split(strings.TrimRight(text, "\n"), "\n")
+}
+
+func (h *prettyHandler) HandleLog(e *alog.Entry) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %-5s %s\n", e.Timestamp.Format(time.RFC3339Nano), strings.ToUpper(e.Level.String()), e.Message)
+	for _, name := range e.Fields.Names() {
+		lines := prettyValue(e.Fields.Get(name))
+		if len(lines) == 1 {
+			fmt.Fprintf(&buf, "    %s: %s\n", name, lines[0])
+			continue
+		}
+		fmt.Fprintf(&buf, "    %s:\n", name)
+		for _, line := range lines {
+			fmt.Fprintf(&buf, "        %s\n", line)
+		}
+	}
+	buf.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
+}
+
+// makeOutputHandler returns the handler that writes entries to out in
+// the format chosen by the parameters.
+func makeOutputHandler(p *parameters, out io.Writer) (alog.Handler, error) {
+	switch p.outputFormat() {
+	case formatCLI:
+		return cli.New(out), nil
+	case formatJSON:
+		return newJSONHandler(out), nil
+	case formatTemplate:
+		tmpl, err := parseTemplate(*p.template)
+		if err != nil {
+			return nil, err
+		}
+		return newTemplateHandler(out, tmpl), nil
+	case formatPretty:
+		return newPrettyHandler(out), nil
+	case formatTerminal:
+		var fields []string
+		if len(p.shownFields) > 0 {
+			fields = splitList(p.shownFields)
+		}
+		return newTerminalHandler(out, terminalOptions{
+			color:     useColor(*p.color, out),
+			highlight: splitList(p.highlightFields),
+			fields:    fields,
+			timeMode:  *p.timeMode,
+		}), nil
+	default:
+		return logfmt.New(out), nil
+	}
+}
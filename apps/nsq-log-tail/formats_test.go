@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	alog "github.com/apex/log"
+)
+
+// formatFields are fields that the output formats treat specially.
+var formatFields = alog.Fields{
+	"stack":   "main.main()\n\tmain.go:12",
+	"request": `{"id":1}`,
+}
+
+func TestJSONHandler(t *testing.T) {
+	var out bytes.Buffer
+	if err := newJSONHandler(&out).HandleLog(testEntry("billing", "", alog.ErrorLevel, "payment <declined>", formatFields)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "<declined>") {
+		t.Errorf("Expected HTML characters to be left alone, got %q", out.String())
+	}
+	entry := &alog.Entry{}
+	if err := json.Unmarshal(out.Bytes(), entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Message != "payment <declined>" || entry.Fields.Get("service") != "billing" || entry.Level != alog.ErrorLevel {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+}
+
+func TestTemplateHandler(t *testing.T) {
+	tmpl, err := parseTemplate(`{{.Time.Format "15:04"}} {{.Level}} {{.Fields.service}} {{.Fields.missing}}{{.Message}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := newTemplateHandler(&out, tmpl).HandleLog(testEntry("billing", "", alog.ErrorLevel, "payment <declined>", formatFields)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "09:00 error billing <no value>payment <declined>\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestPrettyHandler(t *testing.T) {
+	var out bytes.Buffer
+	if err := newPrettyHandler(&out).HandleLog(testEntry("billing", "", alog.ErrorLevel, "payment <declined>", formatFields)); err != nil {
+		t.Fatal(err)
+	}
+	expected := "2017-06-01T09:00:00Z ERROR payment <declined>\n" +
+		"    request:\n" +
+		"        {\n" +
+		"          \"id\": 1\n" +
+		"        }\n" +
+		"    service: billing\n" +
+		"    stack:\n" +
+		"        main.main()\n" +
+		"        \tmain.go:12\n" +
+		"\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -1,5 +1,5 @@
 /*
-nsq-log-tail is a program that will monitor a topic on one or more nsqd instances and attempt to convert messages on that topic into human readable log output.
+nsq-log-tail is a program that will monitor one or more topics on one or more nsqd instances and attempt to convert messages on those topics into human readable log output.
 */
 
 package main
@@ -8,20 +8,20 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
+	"os/user"
+	"regexp"
 	"strings"
 	"syscall"
 	"time"
 
 	"code.avct.io/apexovernsq"
-	"code.avct.io/apexovernsq/protobuf"
 
 	alog "github.com/apex/log"
-	"github.com/apex/log/handlers/cli"
-	"github.com/apex/log/handlers/logfmt"
 	nsq "github.com/nsqio/go-nsq"
 )
 
@@ -36,25 +36,48 @@ func (n *stringFlags) String() string {
 	return strings.Join(*n, ",")
 }
 
-func listenToNSQ(consumer *nsq.Consumer, p *parameters) error {
+func listenToNSQ(t *tail, bounded *boundedHandler, p *parameters) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	err := consumer.ConnectToNSQDs(p.nsqdTCPAddrs)
-	if err != nil {
-		return err
+	for _, topic := range p.topics {
+		if err := t.addTopic(topic); err != nil {
+			return err
+		}
 	}
+	// nsqlookupd may be unavailable for a while, so failing to find
+	// topics matching --topic-pattern isn't fatal, at startup or
+	// later on.
+	t.refreshTopics()
 
-	err = consumer.ConnectToNSQLookupds(p.lookupdHTTPAddrs)
-	if err != nil {
-		return err
+	var timeout <-chan time.Time
+	if *p.timeout > 0 {
+		timer := time.NewTimer(*p.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var refresh <-chan time.Time
+	if len(p.topicPatterns) > 0 {
+		if len(t.topics()) == 0 {
+			log.Printf("No topics match --topic-pattern yet, checking again every %s", *p.topicRefresh)
+		}
+		ticker := time.NewTicker(*p.topicRefresh)
+		defer ticker.Stop()
+		refresh = ticker.C
 	}
 	for {
 		select {
-		case <-consumer.StopChan:
+		case <-refresh:
+			t.refreshTopics()
+		case <-bounded.Done():
+			t.stop()
+			return nil
+		case <-timeout:
+			t.stop()
 			return nil
 		case <-sigChan:
-			consumer.Stop()
+			t.stop()
+			return nil
 		}
 	}
 }
@@ -64,59 +87,411 @@ func generateEphemeralChannelName() string {
 	return fmt.Sprintf("tail%06d#ephemeral", rand.Int()%999999)
 }
 
-func logFromNSQ(p *parameters) error {
-	var handler alog.Handler
-	var logHandler nsq.Handler
+// currentUser returns the name of the user running the program.
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// resumeChannelName returns the durable channel used by --resume,
+// which is the same every time a user tails a topic.
+func resumeChannelName(userName, topic string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, "tail-"+userName+"-"+topic)
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
 
-	cfg := nsq.NewConfig()
-	channel := generateEphemeralChannelName()
-	consumer, err := nsq.NewConsumer(*p.topic, channel, cfg)
+// channelName returns the channel to consume from on a topic: the one
+// given by --channel, the one derived by --resume, or a new ephemeral
+// one.
+func (p *parameters) channelName(topic string) string {
+	switch {
+	case *p.channel != "":
+		return *p.channel
+	case *p.resume:
+		return resumeChannelName(currentUser(), topic)
+	default:
+		return generateEphemeralChannelName()
+	}
+}
+
+// warnAboutBacklog reports how many messages are waiting on a durable
+// channel, so that whoever is watching knows that they're about to
+// see old entries.
+func warnAboutBacklog(p *parameters, topic, channel string) {
+	depth, err := backlog(p, topic, channel)
 	if err != nil {
-		return err
+		log.Printf("Could not check the backlog on channel %q of topic %q: %s", channel, topic, err)
+		return
 	}
-	handler = logfmt.New(os.Stdout)
-	if *p.useCLIHandler {
-		handler = cli.New(os.Stdout)
+	if depth > 0 {
+		log.Printf("Channel %q of topic %q has %d messages waiting, which will be output first", channel, topic, depth)
+	}
+}
+
+// splitList returns the names given to a repeatable flag, such as
+// --levels, whose values may each be a comma separated list.
+func splitList(values stringFlags) []string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
 	}
+	return names
+}
+
+// match builds a MatchFunc from the filtering parameters.  An entry
+// must satisfy every one of them to be output.
+func (p *parameters) match() (apexovernsq.MatchFunc, error) {
+	var matchers []apexovernsq.MatchFunc
+
+	if *p.minLevel != "" {
+		level, err := alog.ParseLevel(*p.minLevel)
+		if err != nil {
+			return nil, fmt.Errorf("unknown level %q", *p.minLevel)
+		}
+		matchers = append(matchers, apexovernsq.MatchMinLevel(level))
+	}
+	if names := splitList(p.levels); len(names) > 0 {
+		levels := make([]alog.Level, 0, len(names))
+		for _, name := range names {
+			level, err := alog.ParseLevel(name)
+			if err != nil {
+				return nil, fmt.Errorf("unknown level %q", name)
+			}
+			levels = append(levels, level)
+		}
+		matchers = append(matchers, apexovernsq.MatchLevels(levels...))
+	}
+	if len(p.hosts) > 0 {
+		hosts := make([]apexovernsq.MatchFunc, 0, len(p.hosts))
+		for _, host := range p.hosts {
+			hosts = append(hosts, apexovernsq.MatchField("hostname", host))
+		}
+		matchers = append(matchers, apexovernsq.MatchOneOf(hosts...))
+	}
+	for _, predicate := range p.fields {
+		match, err := apexovernsq.ParseFieldPredicate(predicate)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, match)
+	}
+	if *p.grep != "" {
+		re, err := regexp.Compile(*p.grep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --grep pattern: %s", err)
+		}
+		matchers = append(matchers, apexovernsq.MatchMessage(re))
+	}
+	if *p.grepV != "" {
+		re, err := regexp.Compile(*p.grepV)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --grep-v pattern: %s", err)
+		}
+		matchers = append(matchers, apexovernsq.MatchNot(apexovernsq.MatchMessage(re)))
+	}
+	return apexovernsq.MatchAll(matchers...), nil
+}
+
+// untilMatchRegexp returns the compiled --until-match expression, or nil if
+// it wasn't given.
+func (p *parameters) untilMatchRegexp() (*regexp.Regexp, error) {
+	if *p.untilMatch == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(*p.untilMatch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --until-match pattern: %s", err)
+	}
+	return re, nil
+}
+
+// makeHandler builds the chain of handlers that selects and writes
+// out entries, as directed by the parameters.  The boundedHandler in
+// the chain, which counts the entries written out, is returned too.
+func makeHandler(p *parameters, out io.Writer) (alog.Handler, *boundedHandler, error) {
+	var output alog.Handler
+	var err error
+	if *p.stats {
+		output = newStatsCollector(*p.statsTop)
+	} else {
+		output, err = makeOutputHandler(p, out)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	untilMatch, err := p.untilMatchRegexp()
+	if err != nil {
+		return nil, nil, err
+	}
+	bounded := newBoundedHandler(output, *p.count, untilMatch)
+	match, err := p.match()
+	if err != nil {
+		return nil, nil, err
+	}
+	var handler alog.Handler = apexovernsq.NewFilterHandler(bounded, match)
 	if p.services != nil {
 		strings := []string(p.services)
-		serviceFilter := apexovernsq.NewApexLogServiceFilterHandler(handler, &strings)
-		logHandler = apexovernsq.NewNSQApexLogHandler(serviceFilter, protobuf.Unmarshal)
-	} else {
-		logHandler = apexovernsq.NewNSQApexLogHandler(handler, protobuf.Unmarshal)
+		handler = apexovernsq.NewApexLogServiceFilterHandler(handler, &strings)
 	}
-	consumer.AddHandler(logHandler)
+	return handler, bounded, nil
+}
 
-	return listenToNSQ(consumer, p)
+// statsWriter returns the function that writes --stats snapshots to
+// out in the chosen format.
+func statsWriter(p *parameters, out io.Writer) func(statsSnapshot) error {
+	if *p.statsFormat == statsFormatJSON {
+		return func(snap statsSnapshot) error {
+			return writeStatsJSON(out, snap)
+		}
+	}
+	clear := false
+	if f, ok := out.(*os.File); ok {
+		clear = isTerminal(f)
+	}
+	return func(snap statsSnapshot) error {
+		return writeStatsTable(out, snap, clear)
+	}
+}
+
+// logFromNSQ tails the topics until it is interrupted, or until the
+// bounds set by --count, --timeout or --until-match are reached, and
+// returns the code to exit with.
+func logFromNSQ(p *parameters) (int, error) {
+	// NSQApexLogHandler drops entries below the global level, so
+	// let everything through and leave the filtering to makeHandler.
+	alog.SetLevel(alog.DebugLevel)
+
+	handler, bounded, err := makeHandler(p, os.Stdout)
+	if err != nil {
+		return exitError, err
+	}
+	t, err := newTail(p, handler)
+	if err != nil {
+		return exitError, err
+	}
+	if stats, ok := bounded.handler.(*statsCollector); ok {
+		stop := stats.report(*p.statsInterval, statsWriter(p, os.Stdout))
+		defer stop()
+	}
+	if err := listenToNSQ(t, bounded, p); err != nil {
+		return exitError, err
+	}
+	return bounded.exitCode(), nil
 }
 
 type parameters struct {
-	topic            *string
+	topics           stringFlags
+	topicPatterns    stringFlags
+	topicRefresh     *time.Duration
+	showTopic        *bool
+	count            *int
+	timeout          *time.Duration
+	untilMatch       *string
+	stats            *bool
+	statsInterval    *time.Duration
+	statsTop         *int
+	statsFormat      *string
+	channel          *string
+	resume           *bool
 	useCLIHandler    *bool
+	format           *string
+	template         *string
+	minLevel         *string
+	levels           stringFlags
+	grep             *string
+	grepV            *string
+	fields           stringFlags
+	hosts            stringFlags
+	highlightFields  stringFlags
+	shownFields      stringFlags
+	timeMode         *string
+	color            *string
 	services         stringFlags
 	nsqdTCPAddrs     stringFlags
 	lookupdHTTPAddrs stringFlags
+	nsqdHTTPAddrs    stringFlags
+
+	consumerConfig        *nsq.Config
+	consumerOpts          apexovernsq.NSQConfigFlag
+	tls                   *bool
+	tlsRootCAFile         *string
+	tlsCert               *string
+	tlsKey                *string
+	tlsInsecureSkipVerify *bool
+	authSecret            *string
+	authSecretFile        *string
+	maxInFlight           *int
 }
 
 func newParameters() *parameters {
 	p := &parameters{
-		topic:            flag.String("topic", "", "NSQ topic to consume from [Required]"),
-		useCLIHandler:    flag.Bool("cli", false, "Use CLI output handler"),
+		topics:           stringFlags{},
+		topicPatterns:    stringFlags{},
+		topicRefresh:     flag.Duration("topic-refresh", 30*time.Second, "how often to look for new topics matching --topic-pattern"),
+		showTopic:        flag.Bool("show-topic", false, "add the topic each log was consumed from, as the \""+topicField+"\" field, or as a column with --format terminal"),
+		count:            flag.Int("count", 0, "exit after this many logs have been output, 0 for no limit"),
+		timeout:          flag.Duration("timeout", 0, "exit after this long, 0 for no limit"),
+		untilMatch:       flag.String("until-match", "", "exit after outputting a log whose message matches this regular expression. The exit status is 0 if a match was seen, or 2 if --count or --timeout ended the tail first"),
+		stats:            flag.Bool("stats", false, "instead of outputting logs, periodically output statistics about them: rates by service, level and host, the commonest messages and the error rate"),
+		statsInterval:    flag.Duration("stats-interval", 5*time.Second, "how often --stats outputs statistics"),
+		statsTop:         flag.Int("stats-top", 10, "how many of the commonest messages --stats shows"),
+		statsFormat:      flag.String("stats-format", statsFormatTerminal, "how --stats outputs statistics: terminal, as a table, or json, as one object per line"),
+		channel:          flag.String("channel", "", "durable NSQ channel to consume from, so that messages published while disconnected are output on reconnection. If neither --channel nor --resume is specified, a new ephemeral channel is used"),
+		resume:           flag.Bool("resume", false, "consume from a durable channel named after the user and topic, the same as --channel with that name"),
+		useCLIHandler:    flag.Bool("cli", false, "Use CLI output handler, the same as --format cli"),
+		format:           flag.String("format", formatLogfmt, "output format: "+strings.Join(formats, ", ")),
+		template:         flag.String("template", "", "Go text/template used by --format template, executed with .Level, .Time, .Message and .Fields, for example '{{.Time}} {{.Fields.service}} {{.Message}}'"),
+		minLevel:         flag.String("level", "", "minimum level to output logs for: debug, info, warn, error or fatal"),
+		levels:           stringFlags{},
+		grep:             flag.String("grep", "", "only output logs whose message matches this regular expression"),
+		grepV:            flag.String("grep-v", "", "only output logs whose message does not match this regular expression"),
+		fields:           stringFlags{},
+		hosts:            stringFlags{},
+		highlightFields:  stringFlags{},
+		shownFields:      stringFlags{},
+		timeMode:         flag.String("time", timeAbsolute, "how --format terminal shows times: absolute, relative or none"),
+		color:            flag.String("color", colorAuto, "whether --format terminal uses color: auto, always or never. auto uses color when writing to a terminal"),
 		services:         stringFlags{},
 		nsqdTCPAddrs:     stringFlags{},
 		lookupdHTTPAddrs: stringFlags{},
+		nsqdHTTPAddrs:    stringFlags{},
+
+		consumerConfig:        nsq.NewConfig(),
+		tls:                   flag.Bool("tls", false, "connect to nsqd using TLS"),
+		tlsRootCAFile:         flag.String("tls-root-ca-file", "", "CA certificate file used to verify nsqd, implies --tls"),
+		tlsCert:               flag.String("tls-cert", "", "client certificate file presented to nsqd, implies --tls"),
+		tlsKey:                flag.String("tls-key", "", "client key file for --tls-cert"),
+		tlsInsecureSkipVerify: flag.Bool("tls-insecure-skip-verify", false, "don't verify nsqd's certificate, implies --tls"),
+		authSecret:            flag.String("auth-secret", "", "secret sent to nsqd to authenticate, defaults to $"+authSecretEnv),
+		authSecretFile:        flag.String("auth-secret-file", "", "file holding the secret sent to nsqd to authenticate"),
+		maxInFlight:           flag.Int("max-in-flight", 0, "maximum number of messages in flight to the tail, 0 for go-nsq's default"),
 	}
+	p.consumerOpts.Config = p.consumerConfig
+	flag.Var(&p.consumerOpts, "consumer-opt", "go-nsq consumer option, as option=value or option,value (may be given multiple times). See http://godoc.org/github.com/nsqio/go-nsq#Config")
+	flag.Var(&p.topics, "topic", "NSQ topic to consume from (may be given multiple times) [Required unless --topic-pattern is given]")
+	flag.Var(&p.topicPatterns, "topic-pattern", "glob or \"re:\" prefixed regular expression; consume from every topic known to lookupd that matches (may be given multiple times)")
 	flag.Var(&p.nsqdTCPAddrs, "nsqd-tcp-address", "nsqd TCP address (may be given multiple times)")
 	flag.Var(&p.lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
-	flag.Var(&p.services, "service", "service to output logs for (may be given multiple times). If no service flag is specified, logs for all services will be output")
+	flag.Var(&p.nsqdHTTPAddrs, "nsqd-http-address", "nsqd HTTP address, used with --nsqd-tcp-address to check the backlog on a durable channel (may be given multiple times)")
+	flag.Var(&p.levels, "levels", "comma separated levels to output logs for (may be given multiple times). If neither --level nor --levels is specified, logs of all levels will be output")
+	flag.Var(&p.fields, "field", "only output logs whose fields satisfy key=value, key!=value or key~=regex (may be given multiple times)")
+	flag.Var(&p.hosts, "host", "hostname to output logs for (may be given multiple times). If no host flag is specified, logs from all hosts will be output")
+	flag.Var(&p.highlightFields, "highlight-field", "comma separated fields that --format terminal shows first, in bold (may be given multiple times)")
+	flag.Var(&p.shownFields, "fields", "comma separated fields that --format terminal shows, hiding the rest (may be given multiple times)")
+	flag.Var(&p.services, "service", "service to output logs for, as a name, glob or \"re:\" prefixed regular expression (may be given multiple times). If no service flag is specified, logs for all services will be output")
 
 	return p
 }
 
+// outputFormat returns the chosen output format, allowing for the
+// older --cli flag.
+func (p *parameters) outputFormat() string {
+	if *p.useCLIHandler {
+		return formatCLI
+	}
+	return *p.format
+}
+
+// check validates the parameters and applies the connection flags to
+// consumerConfig.  It must only be called once, after the flags have
+// been parsed, as the connection flags are applied on top of whatever
+// consumerConfig already holds.
 func (p *parameters) check() error {
-	if *p.topic == "" {
+	if len(p.topics) == 0 && len(p.topicPatterns) == 0 {
 		return errors.New("Please provide a topic")
 	}
+	for _, topic := range p.topics {
+		if !nsq.IsValidTopicName(topic) {
+			return fmt.Errorf("invalid topic name %q", topic)
+		}
+	}
+	for _, pattern := range p.topicPatterns {
+		if _, err := apexovernsq.CompilePattern(pattern); err != nil {
+			return fmt.Errorf("invalid --topic-pattern: %s", err)
+		}
+	}
+	if len(p.topicPatterns) > 0 {
+		if len(p.lookupdHTTPAddrs) == 0 {
+			return errors.New("--topic-pattern requires --lookupd-http-address")
+		}
+		if *p.topicRefresh <= 0 {
+			return errors.New("--topic-refresh must be positive")
+		}
+	}
+	if *p.channel != "" && *p.resume {
+		return errors.New("use --channel or --resume not both")
+	}
+	if *p.channel != "" && !nsq.IsValidChannelName(*p.channel) {
+		return fmt.Errorf("invalid channel name %q", *p.channel)
+	}
+	if _, err := p.match(); err != nil {
+		return err
+	}
+	if err := p.applyConnectionFlags(); err != nil {
+		return err
+	}
+	if *p.count < 0 {
+		return errors.New("--count must not be negative")
+	}
+	if *p.timeout < 0 {
+		return errors.New("--timeout must not be negative")
+	}
+	if _, err := p.untilMatchRegexp(); err != nil {
+		return err
+	}
+	if *p.stats {
+		if *p.statsInterval <= 0 {
+			return errors.New("--stats-interval must be positive")
+		}
+		if *p.statsTop < 1 {
+			return errors.New("--stats-top must be at least 1")
+		}
+		switch *p.statsFormat {
+		case statsFormatTerminal, statsFormatJSON:
+		default:
+			return fmt.Errorf("unknown --stats-format %q", *p.statsFormat)
+		}
+	}
+	switch p.outputFormat() {
+	case formatLogfmt, formatCLI, formatJSON, formatPretty, formatTerminal:
+	case formatTemplate:
+		if *p.template == "" {
+			return errors.New("--format template requires --template")
+		}
+		if _, err := parseTemplate(*p.template); err != nil {
+			return fmt.Errorf("invalid --template: %s", err)
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *p.format)
+	}
+	switch *p.timeMode {
+	case timeAbsolute, timeRelative, timeNone:
+	default:
+		return fmt.Errorf("unknown --time %q", *p.timeMode)
+	}
+	switch *p.color {
+	case colorAuto, colorAlways, colorNever:
+	default:
+		return fmt.Errorf("unknown --color %q", *p.color)
+	}
 
 	if len(p.nsqdTCPAddrs) == 0 && len(p.lookupdHTTPAddrs) == 0 {
 		return errors.New("--nsqd-tcp-address or --lookupd-http-address required")
@@ -134,8 +509,9 @@ func main() {
 		flag.PrintDefaults()
 		log.Fatal(err)
 	}
-	err := logFromNSQ(p)
+	code, err := logFromNSQ(p)
 	if err != nil {
 		log.Fatal(err)
 	}
+	os.Exit(code)
 }
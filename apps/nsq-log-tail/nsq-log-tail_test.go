@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	alog "github.com/apex/log"
+	nsq "github.com/nsqio/go-nsq"
+)
 
 func assertError(t *testing.T, err error, expected string) {
 	if err == nil {
@@ -13,24 +21,230 @@ func assertError(t *testing.T, err error, expected string) {
 	}
 }
 
-func TestCheckParameters(t *testing.T) {
-	p := newParameters()
-	*p.topic = ""
-	err := p.check()
-	assertError(t, err, "Please provide a topic")
+// The parameters register flags, so they can only be created once.
+var testParameters = newParameters()
 
-	*p.topic = "thisworks"
-	err = p.check()
-	assertError(t, err, "--nsqd-tcp-address or --lookupd-http-address required")
+// resetParameters returns the test parameters with every flag set
+// back to its default.
+func resetParameters() *parameters {
+	p := testParameters
+	p.topics = stringFlags{}
+	p.topicPatterns = stringFlags{}
+	*p.topicRefresh = 30 * time.Second
+	*p.showTopic = false
+	*p.count = 0
+	*p.timeout = 0
+	*p.untilMatch = ""
+	*p.stats = false
+	*p.statsInterval = 5 * time.Second
+	*p.statsTop = 10
+	*p.statsFormat = statsFormatTerminal
+	*p.channel = ""
+	*p.resume = false
+	*p.useCLIHandler = false
+	*p.format = formatLogfmt
+	*p.template = ""
+	*p.minLevel = ""
+	*p.grep = ""
+	*p.grepV = ""
+	p.levels = stringFlags{}
+	p.fields = stringFlags{}
+	p.hosts = stringFlags{}
+	p.highlightFields = stringFlags{}
+	p.shownFields = stringFlags{}
+	*p.timeMode = timeAbsolute
+	*p.color = colorAuto
+	p.services = stringFlags{}
+	p.nsqdTCPAddrs = stringFlags{}
+	p.lookupdHTTPAddrs = stringFlags{}
+	p.nsqdHTTPAddrs = stringFlags{}
+	p.consumerConfig = nsq.NewConfig()
+	p.consumerOpts.Config = p.consumerConfig
+	*p.tls = false
+	*p.tlsRootCAFile = ""
+	*p.tlsCert = ""
+	*p.tlsKey = ""
+	*p.tlsInsecureSkipVerify = false
+	*p.authSecret = ""
+	*p.authSecretFile = ""
+	*p.maxInFlight = 0
+	return p
+}
+
+// testEntry returns an entry at a fixed time from a service on a
+// host, with any extra fields.  An empty service or host is left out.
+func testEntry(service, host string, level alog.Level, message string, fields alog.Fields) *alog.Entry {
+	all := alog.Fields{}
+	if service != "" {
+		all["service"] = service
+	}
+	if host != "" {
+		all["hostname"] = host
+	}
+	for name, value := range fields {
+		all[name] = value
+	}
+	return &alog.Entry{
+		Fields:    all,
+		Level:     level,
+		Message:   message,
+		Timestamp: time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC),
+	}
+}
 
-	p.lookupdHTTPAddrs.Set("foo")
-	p.nsqdTCPAddrs.Set("bar")
-	err = p.check()
-	assertError(t, err, "use --nsqd-tcp-address or --lookupd-http-address not both")
+// TestCheckParameters starts each case from valid parameters, applies
+// the case's changes, and expects check to return an error starting
+// with err, or no error if err is empty.
+func TestCheckParameters(t *testing.T) {
+	cases := []struct {
+		name string
+		set  func(p *parameters)
+		err  string
+	}{
+		{"valid", func(p *parameters) {}, ""},
+		{"no topic", func(p *parameters) { p.topics = stringFlags{} }, "Please provide a topic"},
+		{"no address", func(p *parameters) { p.nsqdTCPAddrs = stringFlags{} }, "--nsqd-tcp-address or --lookupd-http-address required"},
+		{"both addresses", func(p *parameters) { p.lookupdHTTPAddrs.Set("foo") }, "use --nsqd-tcp-address or --lookupd-http-address not both"},
+		{"unknown min level", func(p *parameters) { *p.minLevel = "loud" }, `unknown level "loud"`},
+		{"unknown level", func(p *parameters) {
+			*p.minLevel = "warn"
+			p.levels.Set("info,quiet")
+		}, `unknown level "quiet"`},
+		{"field predicate", func(p *parameters) { p.fields.Set("customer_id") }, `invalid field predicate "customer_id": expected key=value, key!=value or key~=regex`},
+		{"grep pattern", func(p *parameters) { *p.grep = "(" }, "invalid --grep pattern"},
+		{"unknown format", func(p *parameters) { *p.format = "xml" }, `unknown format "xml"`},
+		{"template format", func(p *parameters) { *p.format = formatTemplate }, "--format template requires --template"},
+		{"invalid template", func(p *parameters) {
+			*p.format = formatTemplate
+			*p.template = "{{.Message"
+		}, "invalid --template"},
+		{"valid template", func(p *parameters) {
+			*p.format = formatTemplate
+			*p.template = "{{.Message}}"
+		}, ""},
+		{"channel and resume", func(p *parameters) {
+			*p.channel = "mine"
+			*p.resume = true
+		}, "use --channel or --resume not both"},
+		{"invalid channel", func(p *parameters) { *p.channel = "not valid" }, `invalid channel name "not valid"`},
+		{"valid channel", func(p *parameters) { *p.channel = "mine" }, ""},
+		{"invalid topic", func(p *parameters) { p.topics = stringFlags{"not valid"} }, `invalid topic name "not valid"`},
+		{"topic pattern without lookupd", func(p *parameters) {
+			p.topics = stringFlags{}
+			p.topicPatterns.Set("log_*")
+		}, "--topic-pattern requires --lookupd-http-address"},
+		{"topic pattern", func(p *parameters) {
+			p.topics = stringFlags{}
+			p.topicPatterns.Set("log_*")
+			p.nsqdTCPAddrs = stringFlags{}
+			p.lookupdHTTPAddrs.Set("foo")
+		}, ""},
+		{"invalid topic pattern", func(p *parameters) {
+			p.topicPatterns.Set("re:(")
+			p.nsqdTCPAddrs = stringFlags{}
+			p.lookupdHTTPAddrs.Set("foo")
+		}, "invalid --topic-pattern"},
+		{"negative count", func(p *parameters) { *p.count = -1 }, "--count must not be negative"},
+		{"negative timeout", func(p *parameters) { *p.timeout = -1 }, "--timeout must not be negative"},
+		{"until-match pattern", func(p *parameters) { *p.untilMatch = "(" }, "invalid --until-match pattern"},
+		{"stats interval", func(p *parameters) {
+			*p.stats = true
+			*p.statsInterval = 0
+		}, "--stats-interval must be positive"},
+		{"stats top", func(p *parameters) {
+			*p.stats = true
+			*p.statsTop = 0
+		}, "--stats-top must be at least 1"},
+		{"stats format", func(p *parameters) {
+			*p.stats = true
+			*p.statsFormat = "xml"
+		}, `unknown --stats-format "xml"`},
+		{"stats", func(p *parameters) {
+			*p.stats = true
+			*p.statsFormat = statsFormatJSON
+		}, ""},
+		{"tls cert without key", func(p *parameters) { *p.tlsCert = "client.pem" }, "--tls-cert and --tls-key must be given together"},
+		{"negative max in flight", func(p *parameters) { *p.maxInFlight = -1 }, "--max-in-flight must not be negative"},
+		{"auth secret twice", func(p *parameters) {
+			*p.authSecret = "sekrit"
+			*p.authSecretFile = "secret.txt"
+		}, "use --auth-secret or --auth-secret-file not both"},
+	}
+	for _, testCase := range cases {
+		p := resetParameters()
+		p.topics.Set("thisworks")
+		p.nsqdTCPAddrs = stringFlags{"bar"}
+		testCase.set(p)
 
-	p.lookupdHTTPAddrs = stringFlags{}
-	err = p.check()
+		err := p.check()
+		switch {
+		case testCase.err == "" && err != nil:
+			t.Errorf("[%s] Expect nil, but got an error: %q", testCase.name, err.Error())
+		case testCase.err != "" && err == nil:
+			t.Errorf("[%s] Expected error %q, nil returned", testCase.name, testCase.err)
+		case testCase.err != "" && !strings.HasPrefix(err.Error(), testCase.err):
+			t.Errorf("[%s] Expected %q, got %q", testCase.name, testCase.err, err.Error())
+		}
+	}
+}
+
+func tailOutput(t *testing.T, p *parameters, entries ...*alog.Entry) string {
+	var out bytes.Buffer
+	handler, _, err := makeHandler(p, &out)
 	if err != nil {
-		t.Errorf("Expect nil, but got an error: %q", err.Error())
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := handler.HandleLog(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.String()
+}
+
+func TestMakeHandlerFiltersLevels(t *testing.T) {
+	p := resetParameters()
+	entries := []*alog.Entry{
+		testEntry("billing", "", alog.DebugLevel, "debug", nil),
+		testEntry("billing", "", alog.InfoLevel, "info", nil),
+		testEntry("billing", "", alog.WarnLevel, "warn", nil),
+		testEntry("billing", "", alog.ErrorLevel, "error", nil),
+	}
+
+	*p.minLevel = "warn"
+	out := tailOutput(t, p, entries...)
+	if strings.Contains(out, "message=info") || !strings.Contains(out, "message=warn") || !strings.Contains(out, "message=error") {
+		t.Errorf("Expected only warn and error entries, got %q", out)
+	}
+
+	*p.minLevel = ""
+	p.levels.Set("debug,error")
+	out = tailOutput(t, p, entries...)
+	if !strings.Contains(out, "message=debug") || strings.Contains(out, "message=info") || strings.Contains(out, "message=warn") || !strings.Contains(out, "message=error") {
+		t.Errorf("Expected only debug and error entries, got %q", out)
+	}
+}
+
+func TestMakeHandlerFiltersFieldsAndMessages(t *testing.T) {
+	p := resetParameters()
+	entry := func(host, customer, message string) *alog.Entry {
+		return testEntry("billing", host, alog.ErrorLevel, message, alog.Fields{"customer_id": customer})
+	}
+	p.fields.Set("customer_id=123")
+	p.hosts.Set("web1")
+	p.hosts.Set("web2")
+	*p.grep = "payment"
+	*p.grepV = "retrying"
+	out := tailOutput(t, p,
+		entry("web1", "123", "payment declined"),
+		entry("web3", "123", "payment failed on web3"),
+		entry("web2", "456", "payment failed for 456"),
+		entry("web2", "123", "payment retrying"),
+		entry("web2", "123", "card expired"),
+		entry("web2", "123", "payment timeout"),
+	)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "payment declined") || !strings.Contains(lines[1], "payment timeout") {
+		t.Errorf("Unexpected output %q", out)
 	}
 }
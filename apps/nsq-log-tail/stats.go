@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	alog "github.com/apex/log"
+)
+
+// The values accepted by --stats-format.
+const (
+	statsFormatTerminal = "terminal"
+	statsFormatJSON     = "json"
+)
+
+// maxStatsNameWidth is the widest a name, typically a message, is
+// allowed to be in the stats table.
+const maxStatsNameWidth = 80
+
+// statsCount is the number of entries with some property, and the
+// rate at which they arrived.
+type statsCount struct {
+	Name  string  `json:"name"`
+	Count int     `json:"count"`
+	Rate  float64 `json:"rate"`
+}
+
+// statsSnapshot summarises the entries seen during one --stats-interval.
+type statsSnapshot struct {
+	Time        time.Time    `json:"time"`
+	Seconds     float64      `json:"seconds"`
+	Total       int          `json:"total"`
+	Rate        float64      `json:"rate"`
+	Errors      int          `json:"errors"`
+	ErrorRate   float64      `json:"error_rate"`
+	Services    []statsCount `json:"services"`
+	Levels      []statsCount `json:"levels"`
+	Hosts       []statsCount `json:"hosts"`
+	TopMessages []statsCount `json:"top_messages"`
+}
+
+// statsCollector is the handler used in place of an output handler by
+// --stats.  It counts entries by service, level, host and message, and
+// periodically turns the counts into a statsSnapshot.
+type statsCollector struct {
+	mu       sync.Mutex
+	top      int
+	start    time.Time
+	total    int
+	errors   int
+	services map[string]int
+	levels   map[string]int
+	hosts    map[string]int
+	messages map[string]int
+	now      func() time.Time
+}
+
+func newStatsCollector(top int) *statsCollector {
+	c := &statsCollector{top: top, now: time.Now}
+	c.reset()
+	return c
+}
+
+// reset starts a new interval.  It must be called with the mutex held,
+// or before the collector is in use.
+func (c *statsCollector) reset() {
+	c.start = c.now()
+	c.total = 0
+	c.errors = 0
+	c.services = make(map[string]int)
+	c.levels = make(map[string]int)
+	c.hosts = make(map[string]int)
+	c.messages = make(map[string]int)
+}
+
+func (c *statsCollector) HandleLog(e *alog.Entry) error {
+	service, _ := e.Fields.Get("service").(string)
+	host, _ := e.Fields.Get("hostname").(string)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.total++
+	if e.Level >= alog.ErrorLevel {
+		c.errors++
+	}
+	c.services[service]++
+	c.levels[e.Level.String()]++
+	c.hosts[host]++
+	c.messages[e.Message]++
+	return nil
+}
+
+// counts turns a map of counts into a list, busiest first, with at
+// most limit items unless limit is zero.
+func counts(m map[string]int, seconds float64, limit int) []statsCount {
+	list := make([]statsCount, 0, len(m))
+	for name, count := range m {
+		list = append(list, statsCount{Name: name, Count: count, Rate: float64(count) / seconds})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Name < list[j].Name
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
+
+// snapshot summarises the current interval and starts a new one.
+func (c *statsCollector) snapshot() statsSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	seconds := now.Sub(c.start).Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	snap := statsSnapshot{
+		Time:        now,
+		Seconds:     seconds,
+		Total:       c.total,
+		Rate:        float64(c.total) / seconds,
+		Errors:      c.errors,
+		Services:    counts(c.services, seconds, 0),
+		Levels:      counts(c.levels, seconds, 0),
+		Hosts:       counts(c.hosts, seconds, 0),
+		TopMessages: counts(c.messages, seconds, c.top),
+	}
+	if c.total > 0 {
+		snap.ErrorRate = float64(c.errors) / float64(c.total)
+	}
+	c.reset()
+	return snap
+}
+
+// report writes a snapshot every interval until the returned function
+// is called, at which point it writes a final snapshot.
+func (c *statsCollector) report(interval time.Duration, write func(statsSnapshot) error) (stop func()) {
+	stopChan := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-stopChan:
+				write(c.snapshot())
+				return
+			}
+			if err := write(c.snapshot()); err != nil {
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stopChan)
+		<-finished
+	}
+}
+
+// writeStatsJSON writes a snapshot as a JSON object on its own line.
+func writeStatsJSON(out io.Writer, snap statsSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// writeStatsTable writes a snapshot as a table.  When clear is true
+// the terminal is cleared first, so that the table refreshes in place.
+func writeStatsTable(out io.Writer, snap statsSnapshot, clear bool) error {
+	var b bytes.Buffer
+	if clear {
+		b.WriteString("\033[H\033[2J")
+	}
+	fmt.Fprintf(&b, "%s  %d entries in %.1fs, %.1f/s, %d errors (%.1f%%)\n",
+		snap.Time.Local().Format("15:04:05"), snap.Total, snap.Seconds, snap.Rate, snap.Errors, snap.ErrorRate*100)
+
+	section := func(title string, list []statsCount) {
+		if len(list) == 0 {
+			return
+		}
+		width := len(title)
+		for _, item := range list {
+			if len(item.Name) > width {
+				width = len(item.Name)
+			}
+		}
+		if width > maxStatsNameWidth {
+			width = maxStatsNameWidth
+		}
+		fmt.Fprintf(&b, "\n%-*s %8s %8s\n", width, title, "COUNT", "PER SEC")
+		for _, item := range list {
+			name := item.Name
+			if name == "" {
+				name = "-"
+			}
+			if len(name) > width {
+				name = name[:width-3] + "..."
+			}
+			fmt.Fprintf(&b, "%-*s %8d %8.1f\n", width, name, item.Count, item.Rate)
+		}
+	}
+	section("SERVICE", snap.Services)
+	section("LEVEL", snap.Levels)
+	section("HOST", snap.Hosts)
+	section("MESSAGE", snap.TopMessages)
+
+	_, err := b.WriteTo(out)
+	return err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	alog "github.com/apex/log"
+)
+
+func TestStatsCollectorSnapshot(t *testing.T) {
+	now := time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)
+	c := newStatsCollector(2)
+	c.now = func() time.Time { return now }
+	c.reset()
+
+	c.HandleLog(testEntry("billing", "web1", alog.ErrorLevel, "payment declined", nil))
+	c.HandleLog(testEntry("billing", "web1", alog.ErrorLevel, "payment declined", nil))
+	c.HandleLog(testEntry("billing", "web2", alog.ErrorLevel, "payment declined", nil))
+	c.HandleLog(testEntry("auth", "web2", alog.InfoLevel, "logged in", nil))
+	c.HandleLog(testEntry("auth", "web2", alog.InfoLevel, "logged out", nil))
+
+	now = now.Add(10 * time.Second)
+	snap := c.snapshot()
+	if snap.Total != 5 || snap.Rate != 0.5 || snap.Errors != 3 || snap.ErrorRate != 0.6 {
+		t.Errorf("Unexpected totals %+v", snap)
+	}
+	if len(snap.Services) != 2 || snap.Services[0] != (statsCount{"billing", 3, 0.3}) {
+		t.Errorf("Unexpected services %+v", snap.Services)
+	}
+	if len(snap.Hosts) != 2 || snap.Hosts[0] != (statsCount{"web2", 3, 0.3}) {
+		t.Errorf("Unexpected hosts %+v", snap.Hosts)
+	}
+	if len(snap.Levels) != 2 || snap.Levels[0].Name != "error" {
+		t.Errorf("Unexpected levels %+v", snap.Levels)
+	}
+	if len(snap.TopMessages) != 2 || snap.TopMessages[0].Name != "payment declined" || snap.TopMessages[1].Name != "logged in" {
+		t.Errorf("Unexpected top messages %+v", snap.TopMessages)
+	}
+
+	now = now.Add(10 * time.Second)
+	snap = c.snapshot()
+	if snap.Total != 0 || len(snap.Services) != 0 {
+		t.Errorf("Expected the counts to be reset, got %+v", snap)
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	snap := statsSnapshot{
+		Time:        time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC),
+		Seconds:     10,
+		Total:       5,
+		Rate:        0.5,
+		Errors:      3,
+		ErrorRate:   0.6,
+		Services:    []statsCount{{"billing", 3, 0.3}, {"", 2, 0.2}},
+		TopMessages: []statsCount{{strings.Repeat("x", 100), 1, 0.1}},
+	}
+
+	var out bytes.Buffer
+	if err := writeStatsJSON(&out, snap); err != nil {
+		t.Fatal(err)
+	}
+	var decoded statsSnapshot
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total != 5 || decoded.Services[0].Name != "billing" {
+		t.Errorf("Unexpected snapshot %+v", decoded)
+	}
+
+	out.Reset()
+	if err := writeStatsTable(&out, snap, false); err != nil {
+		t.Fatal(err)
+	}
+	table := out.String()
+	for _, expected := range []string{
+		"5 entries in 10.0s, 0.5/s, 3 errors (60.0%)",
+		"SERVICE    COUNT  PER SEC\n",
+		"billing        3      0.3\n",
+		"-              2      0.2\n",
+		strings.Repeat("x", 77) + "...",
+	} {
+		if !strings.Contains(table, expected) {
+			t.Errorf("Expected the table to contain %q, got:\n%s", expected, table)
+		}
+	}
+	if strings.Contains(table, "\033[2J") {
+		t.Error("Expected the screen not to be cleared")
+	}
+}
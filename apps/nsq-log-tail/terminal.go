@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	alog "github.com/apex/log"
+)
+
+// The values accepted by --time.
+const (
+	timeAbsolute = "absolute"
+	timeRelative = "relative"
+	timeNone     = "none"
+)
+
+// The values accepted by --color.
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// ANSI colors.
+const (
+	red     = 31
+	green   = 32
+	yellow  = 33
+	blue    = 34
+	magenta = 35
+	cyan    = 36
+	gray    = 90
+)
+
+var levelColors = [...]int{
+	alog.DebugLevel: gray,
+	alog.InfoLevel:  blue,
+	alog.WarnLevel:  yellow,
+	alog.ErrorLevel: red,
+	alog.FatalLevel: red,
+}
+
+// serviceColors are the colors services are given.  Level colors are
+// avoided for the commonest colors, so that services don't look like
+// errors or warnings.
+var serviceColors = []int{green, magenta, cyan, 92, 94, 95, 96, 93}
+
+// serviceColor returns the color for a service, which is the same
+// every time the service is seen, in every run.
+func serviceColor(service string) int {
+	h := fnv.New32a()
+	h.Write([]byte(service))
+	return serviceColors[h.Sum32()%uint32(len(serviceColors))]
+}
+
+// terminalOptions configures a terminalHandler.
+type terminalOptions struct {
+	color     bool
+	highlight []string
+	// fields, when not nil, lists the only other fields shown.
+	fields   []string
+	timeMode string
+}
+
+// terminalHandler writes entries for people watching a terminal.
+// Each entry is written on one line, with the time, level, topic (with
+// --show-topic), service and host in aligned columns, followed by the
+// message and fields.
+type terminalHandler struct {
+	mu           sync.Mutex
+	out          io.Writer
+	options      terminalOptions
+	highlighted  map[string]bool
+	shown        map[string]bool
+	topicWidth   int
+	sourceWidth  int
+	messageWidth int
+	now          func() time.Time
+}
+
+func newTerminalHandler(out io.Writer, options terminalOptions) *terminalHandler {
+	h := &terminalHandler{
+		out:          out,
+		options:      options,
+		highlighted:  make(map[string]bool),
+		messageWidth: 40,
+		now:          time.Now,
+	}
+	for _, name := range options.highlight {
+		h.highlighted[name] = true
+	}
+	if options.fields != nil {
+		h.shown = make(map[string]bool)
+		for _, name := range options.fields {
+			h.shown[name] = true
+		}
+	}
+	return h
+}
+
+// paint wraps text in an ANSI color, if color is enabled.
+func (h *terminalHandler) paint(color int, text string) string {
+	if !h.options.color {
+		return text
+	}
+	return fmt.Sprintf("\033[%dm%s\033[0m", color, text)
+}
+
+// bold makes text bold, if color is enabled.
+func (h *terminalHandler) bold(text string) string {
+	if !h.options.color {
+		return text
+	}
+	return "\033[1m" + text + "\033[0m"
+}
+
+// formatTime renders an entry's timestamp as directed by --time.
+func (h *terminalHandler) formatTime(timestamp time.Time) string {
+	switch h.options.timeMode {
+	case timeNone:
+		return ""
+	case timeRelative:
+		age := h.now().Sub(timestamp)
+		switch {
+		case age < time.Second:
+			age = age.Round(time.Millisecond)
+		case age < time.Minute:
+			age = age.Round(100 * time.Millisecond)
+		default:
+			age = age.Round(time.Second)
+		}
+		return fmt.Sprintf("%10s ago ", age)
+	default:
+		return timestamp.Local().Format("15:04:05.000") + " "
+	}
+}
+
+// pad returns text padded with spaces to width, and the width as it
+// should be from now on.  Columns only ever grow, so that they stay
+// aligned as entries from new sources arrive.
+func pad(text string, width int) (string, int) {
+	if len(text) > width {
+		width = len(text)
+	}
+	return text + strings.Repeat(" ", width-len(text)), width
+}
+
+func (h *terminalHandler) HandleLog(e *alog.Entry) error {
+	service, _ := e.Fields.Get("service").(string)
+	host, _ := e.Fields.Get("hostname").(string)
+	source := service
+	if host != "" {
+		source += "@" + host
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var buf bytes.Buffer
+	buf.WriteString(h.paint(gray, h.formatTime(e.Timestamp)))
+	buf.WriteString(h.paint(levelColors[e.Level], fmt.Sprintf("%-5s", strings.ToUpper(e.Level.String()))))
+	buf.WriteString(" ")
+	var padded string
+	if topic, ok := e.Fields.Get(topicField).(string); ok {
+		padded, h.topicWidth = pad(topic, h.topicWidth)
+		buf.WriteString(padded)
+		buf.WriteString(" ")
+	}
+	padded, h.sourceWidth = pad(source, h.sourceWidth)
+	buf.WriteString(h.paint(serviceColor(service), padded))
+	buf.WriteString(" | ")
+
+	var highlighted, others []string
+	for _, name := range e.Fields.Names() {
+		value := fmt.Sprint(e.Fields.Get(name))
+		switch {
+		case h.highlighted[name]:
+			highlighted = append(highlighted, h.bold(name+"="+value))
+		case name == "service" || name == "hostname" || name == topicField:
+		case h.shown == nil || h.shown[name]:
+			others = append(others, h.paint(gray, name+"=")+value)
+		}
+	}
+	fields := append(highlighted, others...)
+	if len(fields) == 0 {
+		buf.WriteString(e.Message)
+	} else {
+		padded, _ = pad(e.Message, h.messageWidth)
+		buf.WriteString(padded)
+		buf.WriteString(" ")
+		buf.WriteString(strings.Join(fields, " "))
+	}
+	buf.WriteString("\n")
+
+	_, err := h.out.Write(buf.Bytes())
+	return err
+}
+
+// isTerminal reports whether f is a terminal, rather than a file or
+// pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// useColor decides whether to color output, as directed by --color.
+// Automatic detection colors output for terminals, unless the NO_COLOR
+// environment variable is set.
+func useColor(mode string, out io.Writer) bool {
+	switch mode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	default:
+		f, ok := out.(*os.File)
+		return ok && os.Getenv("NO_COLOR") == "" && isTerminal(f)
+	}
+}
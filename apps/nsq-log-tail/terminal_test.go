@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	alog "github.com/apex/log"
+)
+
+func TestTerminalHandlerAlignsColumns(t *testing.T) {
+	var out bytes.Buffer
+	h := newTerminalHandler(&out, terminalOptions{timeMode: timeNone})
+	h.HandleLog(testEntry("billing", "web1", alog.WarnLevel, "one", nil))
+	h.HandleLog(testEntry("auth", "web1", alog.WarnLevel, "two", nil))
+	h.HandleLog(testEntry("billing-eu", "web12", alog.WarnLevel, "three", nil))
+	h.HandleLog(testEntry("auth", "web1", alog.WarnLevel, "four", nil))
+
+	expected := "WARN  billing@web1 | one\n" +
+		"WARN  auth@web1    | two\n" +
+		"WARN  billing-eu@web12 | three\n" +
+		"WARN  auth@web1        | four\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestTerminalHandlerFields(t *testing.T) {
+	var out bytes.Buffer
+	h := newTerminalHandler(&out, terminalOptions{
+		timeMode:  timeNone,
+		highlight: []string{"user"},
+		fields:    []string{"status"},
+	})
+	h.HandleLog(testEntry("billing", "web1", alog.WarnLevel, "paid", alog.Fields{
+		"amount": "12.00",
+		"status": "200",
+		"user":   "tealeg",
+	}))
+
+	expected := "WARN  billing@web1 | paid" + strings.Repeat(" ", 36) + " user=tealeg status=200\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestTerminalHandlerColorAndTime(t *testing.T) {
+	var out bytes.Buffer
+	h := newTerminalHandler(&out, terminalOptions{color: true, timeMode: timeRelative})
+	h.now = func() time.Time { return time.Date(2017, 6, 1, 9, 0, 1, 500000000, time.UTC) }
+	h.HandleLog(testEntry("billing", "web1", alog.WarnLevel, "paid", nil))
+
+	line := out.String()
+	if !strings.Contains(line, "1.5s ago") {
+		t.Errorf("Expected a relative time, got %q", line)
+	}
+	if !strings.Contains(line, "\033[33mWARN \033[0m") {
+		t.Errorf("Expected a colored level, got %q", line)
+	}
+	if !strings.Contains(line, fmt.Sprintf("\033[%dmbilling@web1\033[0m", serviceColor("billing"))) {
+		t.Errorf("Expected the service's color, got %q", line)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	var out bytes.Buffer
+	if !useColor(colorAlways, &out) {
+		t.Error("Expected --color always to use color")
+	}
+	if useColor(colorNever, &out) {
+		t.Error("Expected --color never not to use color")
+	}
+	if useColor(colorAuto, &out) {
+		t.Error("Expected --color auto not to use color for a buffer")
+	}
+}
+
+func TestTerminalHandlerTopicColumn(t *testing.T) {
+	var out bytes.Buffer
+	h := newTerminalHandler(&out, terminalOptions{timeMode: timeNone})
+	h.HandleLog(testEntry("billing", "web1", alog.WarnLevel, "one", alog.Fields{topicField: "log_errors"}))
+	h.HandleLog(testEntry("billing", "web1", alog.WarnLevel, "two", alog.Fields{topicField: "log"}))
+
+	expected := "WARN  log_errors billing@web1 | one\n" +
+		"WARN  log        billing@web1 | two\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
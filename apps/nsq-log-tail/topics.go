@@ -0,0 +1,196 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.avct.io/apexovernsq"
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// topicField is the field that --show-topic adds to each entry, naming
+// the topic it was consumed from.
+const topicField = "nsq_topic"
+
+// lookupTopics asks nsqlookupd for every topic it knows of.
+func lookupTopics(client *http.Client, lookupdHTTPAddr string) ([]string, error) {
+	var topics struct {
+		Topics []string `json:"topics"`
+	}
+	if err := getJSON(client, httpURL(lookupdHTTPAddr, "/topics", url.Values{}), &topics); err != nil {
+		return nil, err
+	}
+	return topics.Topics, nil
+}
+
+// topicHandler adds the topic an entry was consumed from to its
+// fields.
+type topicHandler struct {
+	topic   string
+	handler alog.Handler
+}
+
+func (h *topicHandler) HandleLog(e *alog.Entry) error {
+	if e.Fields == nil {
+		e.Fields = alog.Fields{}
+	}
+	e.Fields[topicField] = h.topic
+	return h.handler.HandleLog(e)
+}
+
+// tail consumes from any number of topics, with one consumer per
+// topic, and passes every entry to the same handler.
+type tail struct {
+	mu        sync.Mutex
+	p         *parameters
+	handler   alog.Handler
+	patterns  []func(string) bool
+	consumers map[string]*nsq.Consumer
+	stopped   bool
+	client    *http.Client
+}
+
+func newTail(p *parameters, handler alog.Handler) (*tail, error) {
+	t := &tail{
+		p:         p,
+		handler:   handler,
+		consumers: make(map[string]*nsq.Consumer),
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+	for _, pattern := range p.topicPatterns {
+		match, err := apexovernsq.CompilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		t.patterns = append(t.patterns, match)
+	}
+	return t, nil
+}
+
+// matchesPattern reports whether a topic matches any --topic-pattern.
+func (t *tail) matchesPattern(topic string) bool {
+	for _, match := range t.patterns {
+		if match(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTopic starts consuming from a topic, unless that's already
+// happening.  The lock is only held to look at and update the
+// consumers, so that checking the backlog and connecting, which can
+// take a while, don't hold up the other topics.
+func (t *tail) addTopic(topic string) error {
+	if t.consuming(topic) {
+		return nil
+	}
+
+	channel := t.p.channelName(topic)
+	if !strings.HasSuffix(channel, "#ephemeral") {
+		log.Printf("Using durable channel %q on topic %q, which keeps collecting messages until it is deleted", channel, topic)
+		warnAboutBacklog(t.p, topic, channel)
+	}
+	consumer, err := nsq.NewConsumer(topic, channel, t.p.consumerConfig)
+	if err != nil {
+		return err
+	}
+	var handler alog.Handler = t.handler
+	if *t.p.showTopic {
+		handler = &topicHandler{topic: topic, handler: handler}
+	}
+	consumer.AddHandler(apexovernsq.NewNSQApexLogHandler(handler, protobuf.Unmarshal))
+
+	if err := consumer.ConnectToNSQDs(t.p.nsqdTCPAddrs); err != nil {
+		consumer.Stop()
+		return err
+	}
+	if err := consumer.ConnectToNSQLookupds(t.p.lookupdHTTPAddrs); err != nil {
+		consumer.Stop()
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.consumers[topic]; ok || t.stopped {
+		consumer.Stop()
+		return nil
+	}
+	t.consumers[topic] = consumer
+	return nil
+}
+
+// consuming reports whether a topic is already being consumed from.
+func (t *tail) consuming(topic string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.consumers[topic]
+	return ok
+}
+
+// refreshTopics starts consuming from any topics known to nsqlookupd
+// that match a --topic-pattern and aren't already being consumed.
+// Failures are logged, and don't stop the other nsqlookupd instances
+// being asked or the other topics being consumed from.
+func (t *tail) refreshTopics() {
+	if len(t.patterns) == 0 {
+		return
+	}
+	var found []string
+	for _, lookupdHTTPAddr := range t.p.lookupdHTTPAddrs {
+		topics, err := lookupTopics(t.client, lookupdHTTPAddr)
+		if err != nil {
+			log.Printf("Could not look up topics from %s: %s", lookupdHTTPAddr, err)
+			continue
+		}
+		found = append(found, topics...)
+	}
+	sort.Strings(found)
+	for _, topic := range found {
+		if !t.matchesPattern(topic) {
+			continue
+		}
+		if err := t.addTopic(topic); err != nil {
+			log.Printf("Could not consume from topic %q: %s", topic, err)
+		}
+	}
+}
+
+// topics returns the topics being consumed from.
+func (t *tail) topics() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	topics := make([]string, 0, len(t.consumers))
+	for topic := range t.consumers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// stop stops every consumer and waits for them to finish.  Topics
+// added afterwards are stopped straight away.
+func (t *tail) stop() {
+	t.mu.Lock()
+	t.stopped = true
+	consumers := make([]*nsq.Consumer, 0, len(t.consumers))
+	for _, consumer := range t.consumers {
+		consumers = append(consumers, consumer)
+	}
+	t.mu.Unlock()
+
+	for _, consumer := range consumers {
+		consumer.Stop()
+	}
+	for _, consumer := range consumers {
+		<-consumer.StopChan
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	alog "github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestTailMatchesTopicPatterns(t *testing.T) {
+	p := resetParameters()
+	p.topicPatterns.Set("log_*")
+	p.topicPatterns.Set("re:^audit\\.(eu|us)$")
+	tl, err := newTail(p, memory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for topic, expected := range map[string]bool{
+		"log_billing": true,
+		"log":         false,
+		"audit.eu":    true,
+		"audit.asia":  false,
+	} {
+		if tl.matchesPattern(topic) != expected {
+			t.Errorf("matchesPattern(%q): expected %t", topic, expected)
+		}
+	}
+}
+
+func TestLookupTopics(t *testing.T) {
+	for _, wrapped := range []bool{false, true} {
+		lookupd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/topics" {
+				http.NotFound(w, r)
+				return
+			}
+			topics := `{"topics":["log","log_billing"]}`
+			if wrapped {
+				topics = `{"status_code":200,"status_txt":"OK","data":` + topics + `}`
+			}
+			fmt.Fprint(w, topics)
+		}))
+		topics, err := lookupTopics(http.DefaultClient, lookupd.URL)
+		lookupd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(topics) != 2 || topics[0] != "log" || topics[1] != "log_billing" {
+			t.Errorf("Unexpected topics %q", topics)
+		}
+	}
+}
+
+func TestTopicHandler(t *testing.T) {
+	mem := memory.New()
+	h := &topicHandler{topic: "log", handler: mem}
+	h.HandleLog(&alog.Entry{Message: "no fields"})
+	if len(mem.Entries) != 1 || mem.Entries[0].Fields.Get(topicField) != "log" {
+		t.Errorf("Expected the topic to be added, got %+v", mem.Entries)
+	}
+}
+
+func TestAddTopicConnectionFailure(t *testing.T) {
+	p := resetParameters()
+	p.nsqdTCPAddrs = stringFlags{"127.0.0.1:1"}
+	tl, err := newTail(p, memory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tl.addTopic("log"); err == nil {
+		t.Fatal("Expected an error connecting to a closed port")
+	}
+	if topics := tl.topics(); len(topics) != 0 {
+		t.Errorf("Expected no topics to be consumed, got %q", topics)
+	}
+}
+
+func TestRefreshTopicsCarriesOn(t *testing.T) {
+	lookupd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"topics":["log_auth","log_billing","other"]}`)
+	}))
+	defer lookupd.Close()
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	p := resetParameters()
+	p.topicPatterns.Set("log_*")
+	p.lookupdHTTPAddrs = stringFlags{"127.0.0.1:1", lookupd.URL}
+	p.nsqdTCPAddrs = stringFlags{"127.0.0.1:1"}
+	tl, err := newTail(p, memory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.refreshTopics()
+
+	for _, expected := range []string{
+		"Could not look up topics from 127.0.0.1:1",
+		`Could not consume from topic "log_auth"`,
+		`Could not consume from topic "log_billing"`,
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("Expected %q to be logged, got %q", expected, output.String())
+		}
+	}
+	if strings.Contains(output.String(), `"other"`) {
+		t.Errorf("Expected topics not matching the pattern to be ignored, got %q", output.String())
+	}
+}
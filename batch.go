@@ -0,0 +1,143 @@
+package apexovernsq
+
+import (
+	"sync"
+	"time"
+
+	alog "github.com/apex/log"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// BatchHandler is the interface for anything that can deal with a
+// batch of github.com/apex/log.Entry structs at once.  It's the batch
+// equivalent of github.com/apex/log.Handler, and is used by
+// BatchNSQApexLogHandler.
+type BatchHandler interface {
+	HandleBatch(entries []*alog.Entry) error
+}
+
+// The BatchHandlerFunc type is an adapter to allow the use of
+// ordinary functions as BatchHandlers.
+type BatchHandlerFunc func(entries []*alog.Entry) error
+
+// HandleBatch calls f(entries).
+func (f BatchHandlerFunc) HandleBatch(entries []*alog.Entry) error {
+	return f(entries)
+}
+
+// BatchNSQApexLogHandler is a handler for NSQ that consumes messages
+// who's Body is a marshalled github.com/apex/log.Entry and passes
+// them on in batches, rather than one at a time.
+type BatchNSQApexLogHandler struct {
+	mu            sync.Mutex
+	flushMu       sync.Mutex
+	logger        *alog.Logger
+	handler       BatchHandler
+	unmarshalFunc UnmarshalFunc
+	maxSize       int
+	maxWait       time.Duration
+	entries       []*alog.Entry
+	messages      []*nsq.Message
+	timer         *time.Timer
+}
+
+// NewBatchNSQApexLogHandler creates a new BatchNSQApexLogHandler
+// with a provided BatchHandler and any function that satisfies the
+// UnmarshalFunc interface.
+//
+// Entries are collected until maxSize of them are waiting, or until
+// maxWait has passed since the first of them arrived, whichever
+// happens first.  The collected entries are then passed to the
+// HandleBatch method of the BatchHandler.  If HandleBatch returns nil
+// every message in the batch is finished, otherwise every message in
+// the batch is requeued.
+//
+// Because messages aren't responded to until their batch has been
+// handled, the github.com/nsqio/go-nsq.Consumer's max_in_flight
+// setting should be at least maxSize.  Otherwise batches will only
+// ever be flushed by maxWait expiring.  HandleMessage doesn't wait for
+// the batch to be handled, so a single handler added with AddHandler
+// is enough.
+func NewBatchNSQApexLogHandler(handler BatchHandler, unmarshalFunc UnmarshalFunc, maxSize int, maxWait time.Duration) *BatchNSQApexLogHandler {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	if logger, ok := alog.Log.(*alog.Logger); ok {
+		return &BatchNSQApexLogHandler{
+			logger:        logger,
+			handler:       handler,
+			unmarshalFunc: unmarshalFunc,
+			maxSize:       maxSize,
+			maxWait:       maxWait,
+		}
+	}
+	panic("alog.Log is not an *alog.Logger")
+}
+
+// HandleMessage makes BatchNSQApexLogHandler implement the
+// github.com/nsqio/go-nsq.Handler interface.
+//
+// Messages that can't be unmarshalled are rejected immediately, and
+// messages below the log level are finished immediately.  All other
+// messages have go-nsq's automatic response disabled and are added to
+// the current batch.
+func (bh *BatchNSQApexLogHandler) HandleMessage(m *nsq.Message) error {
+	entry := alog.NewEntry(bh.logger)
+	if err := bh.unmarshalFunc(m.Body, entry); err != nil {
+		return err
+	}
+
+	if entry.Level < bh.logger.Level {
+		return nil
+	}
+
+	m.DisableAutoResponse()
+
+	bh.mu.Lock()
+	bh.entries = append(bh.entries, entry)
+	bh.messages = append(bh.messages, m)
+	full := len(bh.entries) >= bh.maxSize
+	if !full && bh.timer == nil {
+		bh.timer = time.AfterFunc(bh.maxWait, bh.Flush)
+	}
+	bh.mu.Unlock()
+
+	if full {
+		bh.Flush()
+	}
+	return nil
+}
+
+// Flush passes any entries waiting in the current batch to the
+// BatchHandler immediately, and responds to their messages.  Batches
+// are never handled concurrently.
+func (bh *BatchNSQApexLogHandler) Flush() {
+	bh.flushMu.Lock()
+	defer bh.flushMu.Unlock()
+
+	bh.mu.Lock()
+	entries := bh.entries
+	messages := bh.messages
+	bh.entries = nil
+	bh.messages = nil
+	if bh.timer != nil {
+		bh.timer.Stop()
+		bh.timer = nil
+	}
+	bh.mu.Unlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := bh.handler.HandleBatch(entries); err != nil {
+		backupLogger.WithError(err).WithField("size", len(entries)).Error("BatchNSQApexLogHandler could not handle batch, requeueing")
+		for _, m := range messages {
+			m.Requeue(-1)
+		}
+		return
+	}
+	for _, m := range messages {
+		m.Finish()
+	}
+}
@@ -0,0 +1,135 @@
+package apexovernsq
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"code.avct.io/apexovernsq/protobuf"
+
+	alog "github.com/apex/log"
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// recordingDelegate is a github.com/nsqio/go-nsq.MessageDelegate
+// that counts the responses made to messages.
+type recordingDelegate struct {
+	mu       sync.Mutex
+	finished int
+	requeued int
+}
+
+func (d *recordingDelegate) OnFinish(m *nsq.Message) {
+	d.mu.Lock()
+	d.finished++
+	d.mu.Unlock()
+}
+
+func (d *recordingDelegate) OnRequeue(m *nsq.Message, delay time.Duration, backoff bool) {
+	d.mu.Lock()
+	d.requeued++
+	d.mu.Unlock()
+}
+
+func (d *recordingDelegate) OnTouch(m *nsq.Message) {}
+
+func (d *recordingDelegate) counts() (int, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.finished, d.requeued
+}
+
+func makeTestMessage(t *testing.T, delegate nsq.MessageDelegate, msg string) *nsq.Message {
+	body, err := protobuf.Marshal(&alog.Entry{
+		Level:     alog.InfoLevel,
+		Timestamp: time.Now(),
+		Message:   msg,
+	})
+	if err != nil {
+		t.Fatalf("Couldn't marshal log entry: %s", err)
+	}
+	m := nsq.NewMessage(nsq.MessageID{'a', 'b', 'c'}, body)
+	m.Delegate = delegate
+	return m
+}
+
+func TestBatchNSQApexLogHandlerFlushesWhenFull(t *testing.T) {
+	var batches [][]*alog.Entry
+	handler := NewBatchNSQApexLogHandler(BatchHandlerFunc(func(entries []*alog.Entry) error {
+		batches = append(batches, entries)
+		return nil
+	}), protobuf.Unmarshal, 2, time.Hour)
+	delegate := &recordingDelegate{}
+
+	for _, msg := range []string{"one", "two", "three"} {
+		m := makeTestMessage(t, delegate, msg)
+		if err := handler.HandleMessage(m); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if !m.IsAutoResponseDisabled() {
+			t.Error("Expected auto response to be disabled")
+		}
+	}
+
+	if len(batches) != 1 || len(batches[0]) != 2 {
+		t.Fatalf("Expected a single batch of 2 entries, got %v", batches)
+	}
+	if finished, _ := delegate.counts(); finished != 2 {
+		t.Errorf("Expected 2 messages to be finished, got %d", finished)
+	}
+
+	handler.Flush()
+	if len(batches) != 2 || batches[1][0].Message != "three" {
+		t.Fatalf("Expected Flush to hand over the remaining entry, got %v", batches)
+	}
+	if finished, _ := delegate.counts(); finished != 3 {
+		t.Errorf("Expected 3 messages to be finished, got %d", finished)
+	}
+}
+
+func TestBatchNSQApexLogHandlerFlushesAfterMaxWait(t *testing.T) {
+	done := make(chan int, 1)
+	handler := NewBatchNSQApexLogHandler(BatchHandlerFunc(func(entries []*alog.Entry) error {
+		done <- len(entries)
+		return nil
+	}), protobuf.Unmarshal, 10, 10*time.Millisecond)
+
+	handler.HandleMessage(makeTestMessage(t, &recordingDelegate{}, "lonely"))
+	select {
+	case size := <-done:
+		if size != 1 {
+			t.Errorf("Expected a batch of 1 entry, got %d", size)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Batch was not flushed within 1 second")
+	}
+}
+
+func TestBatchNSQApexLogHandlerRequeuesFailedBatch(t *testing.T) {
+	handler := NewBatchNSQApexLogHandler(BatchHandlerFunc(func(entries []*alog.Entry) error {
+		return errors.New("sink unavailable")
+	}), protobuf.Unmarshal, 2, time.Hour)
+	delegate := &recordingDelegate{}
+
+	handler.HandleMessage(makeTestMessage(t, delegate, "one"))
+	handler.HandleMessage(makeTestMessage(t, delegate, "two"))
+
+	finished, requeued := delegate.counts()
+	if finished != 0 || requeued != 2 {
+		t.Errorf("Expected 0 finished and 2 requeued, got %d and %d", finished, requeued)
+	}
+}
+
+func TestBatchNSQApexLogHandlerRejectsBadMessage(t *testing.T) {
+	handler := NewBatchNSQApexLogHandler(BatchHandlerFunc(func(entries []*alog.Entry) error {
+		return nil
+	}), protobuf.Unmarshal, 2, time.Hour)
+	m := nsq.NewMessage(nsq.MessageID{'a', 'b', 'c'}, []byte("not a protobuf"))
+	if err := handler.HandleMessage(m); err == nil {
+		t.Error("Expected an error for an unparseable message")
+	}
+	if m.IsAutoResponseDisabled() {
+		t.Error("Expected go-nsq to respond to an unparseable message")
+	}
+}
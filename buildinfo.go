@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package apexovernsq
+
+import (
+	"path"
+	"runtime/debug"
+)
+
+// embeddedServiceInfo returns what can be learnt from the build
+// information embedded in the binary, given the process name.
+func embeddedServiceInfo(name string) ServiceInfo {
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ServiceInfo{}
+	}
+	return buildServiceInfo(name, build)
+}
+
+// buildServiceInfo extracts what it can from the build information
+// embedded in the binary.  The process name is replaced with the
+// main package's name when the binary was built by "go run", which
+// would otherwise give a name of "main".
+func buildServiceInfo(name string, build *debug.BuildInfo) ServiceInfo {
+	var info ServiceInfo
+	if name == "main" && build.Path != "" && build.Path != "command-line-arguments" {
+		info.Name = path.Base(build.Path)
+	}
+	if build.Main.Version != "" && build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	info.Module = build.Main.Path
+
+	var modified bool
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if info.Revision != "" && modified {
+		info.Revision += "-dirty"
+	}
+	return info
+}
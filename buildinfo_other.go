@@ -0,0 +1,10 @@
+//go:build !go1.18
+// +build !go1.18
+
+package apexovernsq
+
+// embeddedServiceInfo returns an empty ServiceInfo, as the build
+// information needed is only embedded by Go 1.18 and later.
+func embeddedServiceInfo(name string) ServiceInfo {
+	return ServiceInfo{}
+}
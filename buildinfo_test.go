@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package apexovernsq
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestBuildServiceInfo(t *testing.T) {
+	build := &debug.BuildInfo{
+		Path: "example.com/shop/cmd/checkout",
+		Main: debug.Module{Path: "example.com/shop", Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+	info := buildServiceInfo("main", build)
+	expected := ServiceInfo{Name: "checkout", Version: "v1.2.3", Module: "example.com/shop", Revision: "abc123-dirty"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+
+	build.Main.Version = "(devel)"
+	info = buildServiceInfo("checkout-server", build)
+	if info.Name != "" || info.Version != "" {
+		t.Errorf("Expected no name or version, got %+v", info)
+	}
+}
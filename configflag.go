@@ -0,0 +1,24 @@
+package apexovernsq
+
+import (
+	"strings"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+// NSQConfigFlag is a flag.Value, for use with the flag package, that
+// sets any github.com/nsqio/go-nsq option on its Config.  It may be
+// given multiple times.  As well as go-nsq's own "option,value" form
+// it accepts "option=value".  An option given without a value is set
+// to true.
+type NSQConfigFlag struct {
+	nsq.ConfigFlag
+}
+
+// Set makes NSQConfigFlag fulfil the flag.Value interface.
+func (f *NSQConfigFlag) Set(opt string) error {
+	if equals := strings.Index(opt, "="); equals > 0 && !strings.Contains(opt[:equals], ",") {
+		opt = opt[:equals] + "," + opt[equals+1:]
+	}
+	return f.ConfigFlag.Set(opt)
+}
@@ -0,0 +1,24 @@
+package apexovernsq
+
+import (
+	"testing"
+	"time"
+
+	nsq "github.com/nsqio/go-nsq"
+)
+
+func TestNSQConfigFlag(t *testing.T) {
+	cfg := nsq.NewConfig()
+	f := NSQConfigFlag{ConfigFlag: nsq.ConfigFlag{Config: cfg}}
+	for _, opt := range []string{"max_attempts=7", "read_timeout,10s", "snappy", "auth_secret=a=b"} {
+		if err := f.Set(opt); err != nil {
+			t.Fatalf("Unexpected error for %q: %s", opt, err)
+		}
+	}
+	if cfg.MaxAttempts != 7 || cfg.ReadTimeout != 10*time.Second || !cfg.Snappy || cfg.AuthSecret != "a=b" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if err := f.Set("no_such_option=1"); err == nil {
+		t.Error("Expected an error for an unknown option")
+	}
+}
@@ -0,0 +1,101 @@
+package apexovernsq
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/apex/log"
+)
+
+// RequestIDHeader is the HTTP header used to pass a request ID
+// between services.  RequestContextMiddleware honours it on incoming
+// requests and sets it on responses.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest incoming request ID that
+// RequestContextMiddleware will accept.
+const maxRequestIDLength = 128
+
+type contextKey int
+
+const (
+	entryContextKey contextKey = iota
+	requestIDContextKey
+)
+
+// NewContext returns a copy of ctx that carries entry.  The entry can
+// be retrieved further down the call chain with FromContext.
+func NewContext(ctx context.Context, entry *log.Entry) context.Context {
+	return context.WithValue(ctx, entryContextKey, entry)
+}
+
+// FromContext returns the entry carried by ctx.  If ctx carries no
+// entry, a new one is made with NewApexLogServiceContext, so the
+// result is always safe to log with.
+func FromContext(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(entryContextKey).(*log.Entry); ok && entry != nil {
+		return entry
+	}
+	return NewApexLogServiceContext()
+}
+
+// ContextWithFields derives a child of the entry carried by ctx with
+// the provided fields added.  It returns both a copy of ctx carrying
+// the child, and the child itself.
+func ContextWithFields(ctx context.Context, fields log.Fielder) (context.Context, *log.Entry) {
+	entry := FromContext(ctx).WithFields(fields)
+	return NewContext(ctx, entry), entry
+}
+
+// RequestIDFromContext returns the request ID set on ctx by
+// RequestContextMiddleware, or an empty string if there isn't one.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// validRequestID reports whether an incoming request ID is safe to
+// adopt: not empty, not too long, and made only of printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestContextMiddleware wraps an http.Handler so that every
+// request's context carries its own entry, retrievable with
+// FromContext.  The entry is derived from base, or from
+// NewApexLogServiceContext if base is nil, and has the fields
+// "request_id", "method", "path" and "remote_addr" set.
+//
+// The request ID is taken from the incoming RequestIDHeader when it
+// has a sensible value, and is otherwise generated.  Either way it is
+// also set as the RequestIDHeader of the response, and can be
+// retrieved with RequestIDFromContext.
+func RequestContextMiddleware(base *log.Entry, next http.Handler) http.Handler {
+	if base == nil {
+		base = NewApexLogServiceContext()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = randomHex(16)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		entry := base.WithFields(log.Fields{
+			"request_id":  id,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"remote_addr": r.RemoteAddr,
+		})
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(ctx, entry)))
+	})
+}
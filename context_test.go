@@ -0,0 +1,72 @@
+package apexovernsq
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestFromContext(t *testing.T) {
+	mem := memory.New()
+	entry := NewApexLogServiceContextWithHandler(mem)
+	ctx := NewContext(context.Background(), entry)
+	if FromContext(ctx) != entry {
+		t.Error("Expected to get back the entry stored in the context")
+	}
+
+	ctx, child := ContextWithFields(ctx, log.Fields{"user": "tealeg"})
+	if FromContext(ctx) != child {
+		t.Error("Expected the context to carry the child entry")
+	}
+	child.Info("Hello")
+	if user := mem.Entries[0].Fields.Get("user"); user != "tealeg" {
+		t.Errorf("Expected user=\"tealeg\", got user=%v", user)
+	}
+	if service := mem.Entries[0].Fields.Get("service"); service != expectedServiceName {
+		t.Errorf("Expected the child to keep the service fields, got service=%v", service)
+	}
+
+	if FromContext(context.Background()) == nil {
+		t.Error("Expected a fallback entry from an empty context")
+	}
+}
+
+func TestRequestContextMiddleware(t *testing.T) {
+	mem := memory.New()
+	var requestID string
+	handler := RequestContextMiddleware(NewApexLogServiceContextWithHandler(mem), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = RequestIDFromContext(r.Context())
+		FromContext(r.Context()).Info("handling")
+	}))
+
+	request := httptest.NewRequest("GET", "/orders/1", nil)
+	request.Header.Set(RequestIDHeader, "abc-123")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if requestID != "abc-123" {
+		t.Errorf("Expected the incoming request ID to be honoured, got %q", requestID)
+	}
+	if header := recorder.Header().Get(RequestIDHeader); header != "abc-123" {
+		t.Errorf("Expected the request ID on the response, got %q", header)
+	}
+	fields := mem.Entries[0].Fields
+	expected := log.Fields{"request_id": "abc-123", "method": "GET", "path": "/orders/1", "remote_addr": request.RemoteAddr}
+	for name, value := range expected {
+		if fields.Get(name) != value {
+			t.Errorf("Expected %s=%q, got %s=%v", name, value, name, fields.Get(name))
+		}
+	}
+
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(RequestIDHeader, strings.Repeat("x", maxRequestIDLength+1))
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if len(requestID) != 32 {
+		t.Errorf("Expected an over-long request ID to be replaced with a generated one, got %q", requestID)
+	}
+}
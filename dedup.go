@@ -0,0 +1,87 @@
+package apexovernsq
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// seenID records when an entry ID was first seen by a DedupHandler.
+type seenID struct {
+	id   string
+	seen time.Time
+}
+
+// DedupHandler is a github.com/apex/log.Handler that drops entries
+// it has already seen.  NSQ guarantees at-least-once delivery, so
+// requeued messages and retried publications can cause the same
+// entry to arrive more than once; the DedupHandler recognises repeats
+// by their EntryIDField.
+type DedupHandler struct {
+	mu         sync.Mutex
+	handler    log.Handler
+	window     time.Duration
+	maxEntries int
+	seen       map[string]*list.Element
+	order      *list.List
+	now        func() time.Time
+}
+
+// NewDedupHandler returns a pointer to a DedupHandler that passes the
+// first occurrence of every entry to the provided handler.
+//
+// Entry IDs are remembered for the duration of window, and at most
+// maxEntries of them are remembered at once, the oldest being
+// forgotten first.  A window of zero or less places no limit on how
+// long IDs are remembered, and a maxEntries of zero places no limit on
+// the number of IDs remembered; at least one of them should be set, or
+// every ID ever seen is remembered.  Entries without an ID are always
+// passed on.
+func NewDedupHandler(handler log.Handler, window time.Duration, maxEntries int) *DedupHandler {
+	return &DedupHandler{
+		handler:    handler,
+		window:     window,
+		maxEntries: maxEntries,
+		seen:       make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// forget discards remembered IDs that have fallen out of the window,
+// or that exceed the maximum number of IDs to remember.
+func (h *DedupHandler) forget(now time.Time) {
+	for front := h.order.Front(); front != nil; front = h.order.Front() {
+		oldest := front.Value.(seenID)
+		fresh := h.window <= 0 || now.Sub(oldest.seen) < h.window
+		if fresh && (h.maxEntries < 1 || h.order.Len() <= h.maxEntries) {
+			return
+		}
+		h.order.Remove(front)
+		delete(h.seen, oldest.id)
+	}
+}
+
+// HandleLog makes DedupHandler fulfil the interface required by
+// github.com/apex/log for handlers.
+func (h *DedupHandler) HandleLog(e *log.Entry) error {
+	id, ok := e.Fields.Get(EntryIDField).(string)
+	if !ok || id == "" {
+		return h.handler.HandleLog(e)
+	}
+
+	h.mu.Lock()
+	now := h.now()
+	h.forget(now)
+	if _, duplicate := h.seen[id]; duplicate {
+		h.mu.Unlock()
+		return nil
+	}
+	h.seen[id] = h.order.PushBack(seenID{id: id, seen: now})
+	h.forget(now)
+	h.mu.Unlock()
+
+	return h.handler.HandleLog(e)
+}
@@ -0,0 +1,99 @@
+package apexovernsq
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestApexLogNSQHandlerStampsEntryID(t *testing.T) {
+	var ids []string
+	fakePublish := func(topic string, body []byte) error {
+		entry := &log.Entry{}
+		if err := json.Unmarshal(body, entry); err != nil {
+			t.Fatalf("Error unmarshalling log message: %s", err)
+		}
+		id, _ := entry.Fields.Get(EntryIDField).(string)
+		ids = append(ids, id)
+		return nil
+	}
+
+	handler := NewApexLogNSQHandler(json.Marshal, fakePublish, "testing")
+	source := &log.Entry{Fields: log.Fields{"user": "tealeg"}, Message: "Hello"}
+	handler.HandleLog(source)
+	handler.HandleLog(source)
+
+	if len(ids) != 2 || ids[0] == "" || ids[0] == ids[1] {
+		t.Fatalf("Expected 2 distinct entry IDs, got %q", ids)
+	}
+	if _, ok := source.Fields[EntryIDField]; ok {
+		t.Error("Expected the source entry's fields to be left untouched")
+	}
+}
+
+func TestDedupHandlerDropsRepeats(t *testing.T) {
+	mem := memory.New()
+	handler := NewDedupHandler(mem, time.Minute, 10)
+
+	handler.HandleLog(&log.Entry{Message: "a", Fields: log.Fields{EntryIDField: "x-1"}})
+	handler.HandleLog(&log.Entry{Message: "b", Fields: log.Fields{EntryIDField: "x-2"}})
+	handler.HandleLog(&log.Entry{Message: "a again", Fields: log.Fields{EntryIDField: "x-1"}})
+	handler.HandleLog(&log.Entry{Message: "no id", Fields: log.Fields{}})
+	handler.HandleLog(&log.Entry{Message: "no id", Fields: log.Fields{}})
+
+	if count := len(mem.Entries); count != 4 {
+		t.Fatalf("Expected 4 entries, got %d", count)
+	}
+	if mem.Entries[2].Message != "no id" {
+		t.Errorf("Expected the repeated entry to be dropped, got %q", mem.Entries[2].Message)
+	}
+}
+
+func TestDedupHandlerForgetsOutsideWindow(t *testing.T) {
+	mem := memory.New()
+	handler := NewDedupHandler(mem, time.Minute, 0)
+	now := time.Now()
+	handler.now = func() time.Time { return now }
+
+	handler.HandleLog(&log.Entry{Fields: log.Fields{EntryIDField: "x-1"}})
+	now = now.Add(2 * time.Minute)
+	handler.HandleLog(&log.Entry{Fields: log.Fields{EntryIDField: "x-1"}})
+
+	if count := len(mem.Entries); count != 2 {
+		t.Errorf("Expected 2 entries, got %d", count)
+	}
+}
+
+func TestDedupHandlerIsBounded(t *testing.T) {
+	mem := memory.New()
+	handler := NewDedupHandler(mem, time.Hour, 2)
+
+	for _, id := range []string{"x-1", "x-2", "x-3", "x-1"} {
+		handler.HandleLog(&log.Entry{Fields: log.Fields{EntryIDField: id}})
+	}
+
+	if count := len(mem.Entries); count != 4 {
+		t.Errorf("Expected the oldest ID to be forgotten, got %d entries", count)
+	}
+	if count := handler.order.Len(); count != 2 {
+		t.Errorf("Expected 2 remembered IDs, got %d", count)
+	}
+}
+
+func TestDedupHandlerWithoutWindow(t *testing.T) {
+	mem := memory.New()
+	handler := NewDedupHandler(mem, 0, 2)
+	now := time.Now()
+	handler.now = func() time.Time { return now }
+
+	handler.HandleLog(&log.Entry{Fields: log.Fields{EntryIDField: "x-1"}})
+	now = now.Add(24 * time.Hour)
+	handler.HandleLog(&log.Entry{Fields: log.Fields{EntryIDField: "x-1"}})
+
+	if count := len(mem.Entries); count != 1 {
+		t.Errorf("Expected the repeated entry to be dropped, got %d entries", count)
+	}
+}
@@ -0,0 +1,89 @@
+package apexovernsq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	// EntryIDField is the name of the field that the producer
+	// handlers use to give every published entry a unique ID.  The
+	// ID is made of the ProducerIDField and SequenceField values.
+	EntryIDField = "entry_id"
+	// ProducerIDField is the name of the field that identifies the
+	// producing handler that published an entry.  It is made of the
+	// hostname and process ID of the producing process, plus a
+	// random suffix that distinguishes handlers within a process.
+	ProducerIDField = "producer_id"
+	// SequenceField is the name of the field holding an entry's
+	// position in the sequence of entries published by its
+	// producer.  The first entry published has sequence number 1.
+	SequenceField = "seq"
+)
+
+// entryStamper gives each entry passed through it a producer ID, a
+// sequence number and a unique ID made of the two.
+type entryStamper struct {
+	producerID string
+	seq        uint64
+}
+
+func newEntryStamper() *entryStamper {
+	info := CurrentServiceInfo()
+	return &entryStamper{
+		producerID: fmt.Sprintf("%s/%s/%s", info.Hostname, info.Pid, newInstanceID()),
+	}
+}
+
+// newInstanceID returns a short random identifier.
+func newInstanceID() string {
+	return randomHex(4)
+}
+
+// randomHex returns size random bytes, hex encoded.  Should the
+// system's source of randomness fail, the current time is used
+// instead.
+func randomHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// stamp returns a copy of the entry with the EntryIDField,
+// ProducerIDField and SequenceField set.  The provided entry is left
+// untouched, as it may also be in use by other handlers.
+func (s *entryStamper) stamp(e *log.Entry) *log.Entry {
+	seq := strconv.FormatUint(atomic.AddUint64(&s.seq, 1), 10)
+	return copyEntryWithFields(e, log.Fields{
+		ProducerIDField: s.producerID,
+		SequenceField:   seq,
+		EntryIDField:    s.producerID + "-" + seq,
+	})
+}
+
+// copyEntryWithFields returns a copy of the entry with the extra
+// fields added to its own.  The provided entry is not modified.
+func copyEntryWithFields(e *log.Entry, extra log.Fields) *log.Entry {
+	fields := make(log.Fields, len(e.Fields)+len(extra))
+	for key, value := range e.Fields {
+		fields[key] = value
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+	return &log.Entry{
+		Logger:    e.Logger,
+		Fields:    fields,
+		Level:     e.Level,
+		Timestamp: e.Timestamp,
+		Message:   e.Message,
+	}
+}
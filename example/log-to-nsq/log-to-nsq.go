@@ -20,6 +20,8 @@ Not that <IPADDRESS:PORT> should be replaced with the IP address of the machine
 
     ./example -nsqd-address <IPADDRESS:PORT> -topic log
 
+Any go-nsq option can be set with --producer-opt option=value, which may be given multiple times.  For example, to publish to an nsqd that requires TLS pass --producer-opt tls_v1=true and --producer-opt tls_root_ca_file=<FILE>.  If nsqd requires authentication, put the secret in the NSQ_AUTH_SECRET environment variable rather than on the command line.
+
 This program should exit almost immediately, but if you check the nsq_tail process you should see some output that looks like this:
 {"fields":{"flavour":"pistachio","scoops":"two"},"level":"info","timestamp":"2017-08-04T15:48:22.044783085+02:00","message":"It's ice cream time!"}
 {"fields":{"error":"ouch, brainfreeze"},"level":"error","timestamp":"2017-08-04T15:48:22.047870426+02:00","message":"Problem consuming ice cream"}
@@ -31,6 +33,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"strings"
 
 	"code.avct.io/apexovernsq"
@@ -52,12 +55,26 @@ func (n *stringFlags) String() string {
 }
 
 var (
-	topic         = flag.String("topic", "", "NSQ topic to publish to [Required]")
-	nsqdAddresses = stringFlags{}
+	topic          = flag.String("topic", "", "NSQ topic to publish to [Required]")
+	nsqdAddresses  = stringFlags{}
+	producerConfig = nsq.NewConfig()
+	producerOpts   = apexovernsq.NSQConfigFlag{ConfigFlag: nsq.ConfigFlag{Config: producerConfig}}
 )
 
 func init() {
 	flag.Var(&nsqdAddresses, "nsqd-address", "The IP Address of a nsqd you with to publish to. Give this option once for every nsqd [1 or more required].")
+	flag.Var(&producerOpts, "producer-opt", "A go-nsq producer option, as option=value or option,value. May be given multiple times. See http://godoc.org/github.com/nsqio/go-nsq#Config")
+}
+
+// configureProducer sets the auth secret from the environment, if
+// it's there, and validates the producer config.
+func configureProducer(cfg *nsq.Config) error {
+	if secret := os.Getenv("NSQ_AUTH_SECRET"); secret != "" {
+		if err := cfg.Set("auth_secret", secret); err != nil {
+			return err
+		}
+	}
+	return cfg.Validate()
 }
 
 func usage() {
@@ -98,8 +115,10 @@ func main() {
 		log.Fatal("Required parameters missing.")
 	}
 
-	cfg := nsq.NewConfig()
-	producers := makeProducers(nsqdAddresses, cfg)
+	if err := configureProducer(producerConfig); err != nil {
+		log.Fatal(err)
+	}
+	producers := makeProducers(nsqdAddresses, producerConfig)
 	publisher := makePublisher(producers)
 	handler := apexovernsq.NewApexLogNSQHandler(protobuf.Marshal, publisher, "log")
 
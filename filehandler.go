@@ -0,0 +1,480 @@
+package apexovernsq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/go-logfmt/logfmt"
+)
+
+// FileFormat determines how a FileHandler writes entries.
+type FileFormat int
+
+const (
+	// LogfmtFormat writes entries as logfmt records, in the same
+	// layout as github.com/apex/log/handlers/logfmt.
+	LogfmtFormat FileFormat = iota
+	// JSONFormat writes each entry as a JSON object on its own
+	// line, in the same form as encoding/json.Marshal produces for
+	// a github.com/apex/log.Entry.
+	JSONFormat
+)
+
+// SyncPolicy determines when a FileHandler asks the operating system
+// to flush its files to disk.  Files are always synced when they are
+// rotated or closed.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = iota
+	// SyncEveryEntry syncs after every entry is written.
+	SyncEveryEntry
+	// SyncPeriodically syncs every open file at the interval given
+	// by FileHandlerOptions.SyncInterval.
+	SyncPeriodically
+)
+
+// FileHandlerOptions configures a FileHandler.
+type FileHandlerOptions struct {
+	// Dir is the directory under which log files are written.
+	Dir string
+	// Format is the format entries are written in.
+	Format FileFormat
+	// MaxSize, when greater than zero, is the size in bytes above
+	// which a file is rotated.
+	MaxSize int64
+	// MaxAge, when greater than zero, is the longest a file is
+	// written to before it is rotated.
+	MaxAge time.Duration
+	// Compress causes rotated files to be compressed with gzip.
+	Compress bool
+	// Retention, when greater than zero, is how long rotated files
+	// are kept before they are deleted.  Expired files are looked
+	// for when the FileHandler is created, hourly after that, and
+	// whenever a file is rotated.
+	Retention time.Duration
+	// Sync determines when files are synced to disk.
+	Sync SyncPolicy
+	// SyncInterval is the interval used by SyncPeriodically.  It
+	// defaults to one second.
+	SyncInterval time.Duration
+}
+
+// filePruneInterval is how often a FileHandler with a Retention
+// looks for expired files.
+const filePruneInterval = time.Hour
+
+// fileKey identifies the file a FileHandler writes a service's entries
+// for a date to.
+type fileKey struct {
+	service string
+	date    string
+}
+
+// logFile is a file a FileHandler is currently writing to.
+type logFile struct {
+	file   *os.File
+	path   string
+	date   string
+	size   int64
+	opened time.Time
+}
+
+// FileHandler is a github.com/apex/log.Handler that writes entries to
+// files, partitioned by service and date.  It is intended as the final
+// handler in a consumer that archives logs.
+//
+// Entries for a service are written to
+//
+//	<Dir>/<service>/<service>-<YYYY-MM-DD>.log
+//
+// where the date is the UTC date of the entry's Timestamp.  NSQ
+// doesn't guarantee ordering, so entries from either side of midnight
+// arrive mixed together; the file for the previous date is kept open
+// alongside the current one, and a file is only retired once entries
+// for two dates after it have arrived.  When a file is rotated,
+// because it has grown too large or too old or because it has been
+// retired, it is renamed to
+//
+//	<Dir>/<service>/<service>-<YYYY-MM-DD>.<N>.log
+//
+// with N counting up from 1, and is then optionally compressed and
+// eventually deleted, according to the FileHandlerOptions.
+type FileHandler struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	options  FileHandlerOptions
+	files    map[fileKey]*logFile
+	newest   map[string]string
+	stopChan chan bool
+	stop     func()
+	now      func() time.Time
+}
+
+// NewFileHandler returns a pointer to a FileHandler configured by
+// options.  The directory given by options.Dir is created if
+// necessary.  Close should be called when the FileHandler is no
+// longer required.
+func NewFileHandler(options FileHandlerOptions) (*FileHandler, error) {
+	if options.Dir == "" {
+		return nil, fmt.Errorf("a directory is required")
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = time.Second
+	}
+
+	h := &FileHandler{
+		options:  options,
+		files:    make(map[fileKey]*logFile),
+		newest:   make(map[string]string),
+		stopChan: make(chan bool),
+		now:      time.Now,
+	}
+	h.stop = stopOnce(h.stopChan)
+	if options.Retention > 0 {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			h.pruneAll()
+			ticker := time.NewTicker(filePruneInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					h.pruneAll()
+				case <-h.stopChan:
+					return
+				}
+			}
+		}()
+	}
+	if options.Sync == SyncPeriodically {
+		h.wg.Add(1)
+		go func() {
+			defer h.wg.Done()
+			ticker := time.NewTicker(options.SyncInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					h.syncAll()
+				case <-h.stopChan:
+					return
+				}
+			}
+		}()
+	}
+	return h, nil
+}
+
+// ServiceDirName returns the service name of an entry, made safe to
+// use as a directory name.  Entries without a service name, or whose
+// service name can't be used as a directory, are given "unknown".
+func ServiceDirName(e *log.Entry) string {
+	service, _ := fieldString(e, "service")
+	service = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, service)
+	if service == "" || service == "." || service == ".." {
+		return "unknown"
+	}
+	return service
+}
+
+// basePath returns the path, without extension, of the files for a
+// service and date.
+func (h *FileHandler) basePath(service, date string) string {
+	return filepath.Join(h.options.Dir, service, service+"-"+date)
+}
+
+// open returns the file that an entry for service on date should be
+// written to, rotating the file first if it has grown too large or
+// too old.  It must be called with the mutex held.
+func (h *FileHandler) open(service, date string) (*logFile, error) {
+	key := fileKey{service: service, date: date}
+	current, ok := h.files[key]
+	if ok {
+		tooBig := h.options.MaxSize > 0 && current.size >= h.options.MaxSize
+		tooOld := h.options.MaxAge > 0 && h.now().Sub(current.opened) >= h.options.MaxAge
+		if !tooBig && !tooOld {
+			return current, nil
+		}
+		delete(h.files, key)
+		if err := h.rotate(service, current); err != nil {
+			return nil, err
+		}
+	}
+
+	path := h.basePath(service, date) + ".log"
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	current = &logFile{
+		file:   file,
+		path:   path,
+		date:   date,
+		size:   info.Size(),
+		opened: h.now(),
+	}
+	h.files[key] = current
+	if date > h.newest[service] {
+		h.newest[service] = date
+		if err := h.retire(service, date); err != nil {
+			return nil, err
+		}
+	}
+	return current, nil
+}
+
+// retire rotates the service's open files for dates before the day
+// preceding newest.  The file for the preceding day is left open, for
+// entries that arrive late.  It must be called with the mutex held.
+func (h *FileHandler) retire(service, newest string) error {
+	day, err := time.Parse("2006-01-02", newest)
+	if err != nil {
+		return err
+	}
+	cutoff := day.AddDate(0, 0, -1).Format("2006-01-02")
+	for key, current := range h.files {
+		if key.service != service || key.date >= cutoff {
+			continue
+		}
+		delete(h.files, key)
+		if err := h.rotate(service, current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate closes a file and renames it out of the way, then compresses
+// it and prunes old files in the background.  It must be called with
+// the mutex held.
+func (h *FileHandler) rotate(service string, current *logFile) error {
+	current.file.Sync()
+	if err := current.file.Close(); err != nil {
+		return err
+	}
+	base := h.basePath(service, current.date)
+	var rotated string
+	for n := 1; ; n++ {
+		rotated = fmt.Sprintf("%s.%d.log", base, n)
+		if _, err := os.Stat(rotated); err == nil {
+			continue
+		}
+		if _, err := os.Stat(rotated + ".gz"); err == nil {
+			continue
+		}
+		break
+	}
+	if err := os.Rename(current.path, rotated); err != nil {
+		return err
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		if h.options.Compress {
+			if err := compressFile(rotated); err != nil {
+				backupLogger.WithError(err).WithField("path", rotated).Error("FileHandler could not compress rotated file")
+			}
+		}
+		if h.options.Retention > 0 {
+			h.prune(filepath.Dir(rotated))
+		}
+	}()
+	return nil
+}
+
+// compressFile replaces the file at path with a gzipped copy of it.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// isRotatedFile reports whether a file name is that of a rotated log
+// file, as opposed to one still being written to.
+func isRotatedFile(name string) bool {
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasSuffix(name, ".log") {
+		return false
+	}
+	name = strings.TrimSuffix(name, ".log")
+	dot := strings.LastIndex(name, ".")
+	if dot < 0 || dot == len(name)-1 {
+		return false
+	}
+	for _, r := range name[dot+1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneAll deletes expired rotated files for every service.
+func (h *FileHandler) pruneAll() {
+	infos, err := ioutil.ReadDir(h.options.Dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if info.IsDir() {
+			h.prune(filepath.Join(h.options.Dir, info.Name()))
+		}
+	}
+}
+
+// prune deletes rotated files in dir that were last modified longer
+// ago than the retention period.
+func (h *FileHandler) prune(dir string) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := h.now().Add(-h.options.Retention)
+	for _, info := range infos {
+		if info.IsDir() || !isRotatedFile(info.Name()) || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+			backupLogger.WithError(err).WithField("path", info.Name()).Error("FileHandler could not remove expired file")
+		}
+	}
+}
+
+// encode renders an entry in the configured format.
+func (h *FileHandler) encode(e *log.Entry) ([]byte, error) {
+	if h.options.Format == JSONFormat {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+
+	var buf bytes.Buffer
+	enc := logfmt.NewEncoder(&buf)
+	enc.EncodeKeyval("timestamp", e.Timestamp)
+	enc.EncodeKeyval("level", e.Level.String())
+	enc.EncodeKeyval("message", e.Message)
+	for _, name := range e.Fields.Names() {
+		enc.EncodeKeyval(name, e.Fields.Get(name))
+	}
+	if err := enc.EndRecord(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// HandleLog makes FileHandler fulfil the interface required by
+// github.com/apex/log for handlers.
+func (h *FileHandler) HandleLog(e *log.Entry) error {
+	data, err := h.encode(e)
+	if err != nil {
+		return err
+	}
+	timestamp := e.Timestamp
+	if timestamp.IsZero() {
+		timestamp = h.now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	current, err := h.open(ServiceDirName(e), timestamp.UTC().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+	n, err := current.file.Write(data)
+	current.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if h.options.Sync == SyncEveryEntry {
+		return current.file.Sync()
+	}
+	return nil
+}
+
+// syncAll syncs every open file to disk.
+func (h *FileHandler) syncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, current := range h.files {
+		if err := current.file.Sync(); err != nil {
+			backupLogger.WithError(err).WithField("path", current.path).Error("FileHandler could not sync file")
+		}
+	}
+}
+
+// Close syncs and closes every open file, and waits for any
+// compression and pruning to finish.  Files are not rotated on
+// close, so writing resumes in the same files if the FileHandler is
+// recreated.
+func (h *FileHandler) Close() error {
+	h.stop()
+
+	h.mu.Lock()
+	var firstErr error
+	for key, current := range h.files {
+		current.file.Sync()
+		if err := current.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(h.files, key)
+	}
+	h.mu.Unlock()
+
+	h.wg.Wait()
+	return firstErr
+}
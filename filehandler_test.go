@@ -0,0 +1,267 @@
+package apexovernsq
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// testEntry returns an entry from a service at the given time, with
+// any extra fields.  An empty service is left out.
+func testEntry(service string, level log.Level, message string, timestamp time.Time, fields log.Fields) *log.Entry {
+	all := log.Fields{}
+	if service != "" {
+		all["service"] = service
+	}
+	for name, value := range fields {
+		all[name] = value
+	}
+	return &log.Entry{
+		Fields:    all,
+		Level:     level,
+		Message:   message,
+		Timestamp: timestamp,
+	}
+}
+
+func TestFileHandlerPartitionsByServiceAndDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filehandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler, err := NewFileHandler(FileHandlerOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "one", day, nil))
+	handler.HandleLog(testEntry("auth", log.InfoLevel, "two", day, nil))
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "three", day.Add(24*time.Hour), nil))
+	handler.HandleLog(testEntry("../etc", log.InfoLevel, "four", day, nil))
+	if err := handler.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"billing/billing-2017-06-01.log": "one",
+		"billing/billing-2017-06-02.log": "three",
+		"auth/auth-2017-06-01.log":       "two",
+		".._etc/.._etc-2017-06-01.log":   "four",
+	}
+	for name, message := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("Expected file %s: %s", name, err)
+			continue
+		}
+		if !strings.Contains(string(data), "message="+message) {
+			t.Errorf("Expected %s to contain %q, got %q", name, message, data)
+		}
+	}
+}
+
+func TestFileHandlerOutOfOrderDates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filehandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler, err := NewFileHandler(FileHandlerOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	midnight := time.Date(2017, 6, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		handler.HandleLog(testEntry("billing", log.InfoLevel, "before", midnight.Add(-time.Second), nil))
+		handler.HandleLog(testEntry("billing", log.InfoLevel, "after", midnight.Add(time.Second), nil))
+	}
+	names := func() []string {
+		infos, _ := ioutil.ReadDir(filepath.Join(dir, "billing"))
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+	if files := strings.Join(names(), " "); files != "billing-2017-06-01.log billing-2017-06-02.log" {
+		t.Errorf("Expected one file per date, got %s", files)
+	}
+
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "later", midnight.Add(24*time.Hour), nil))
+	handler.Close()
+	if files := strings.Join(names(), " "); files != "billing-2017-06-01.1.log billing-2017-06-02.log billing-2017-06-03.log" {
+		t.Errorf("Expected the file two dates back to be rotated, got %s", files)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "billing", "billing-2017-06-01.1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(string(data), "message=before"); count != 5 {
+		t.Errorf("Expected 5 entries before midnight, got %d", count)
+	}
+}
+
+func TestFileHandlerJSONFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filehandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler, err := NewFileHandler(FileHandlerOptions{Dir: dir, Format: JSONFormat, Sync: SyncEveryEntry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "one", day, nil))
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "two", day, nil))
+	handler.Close()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "billing", "billing-2017-06-01.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	entry := &log.Entry{}
+	if err := json.Unmarshal([]byte(lines[1]), entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Message != "two" || entry.Fields.Get("service") != "billing" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+}
+
+func TestFileHandlerRotatesAndCompresses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filehandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler, err := NewFileHandler(FileHandlerOptions{Dir: dir, MaxSize: 1, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "one", day, nil))
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "two", day, nil))
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "three", day, nil))
+	handler.Close()
+
+	for n, message := range []string{"one", "two"} {
+		name := filepath.Join(dir, "billing", "billing-2017-06-01."+string('1'+rune(n))+".log.gz")
+		file, err := os.Open(name)
+		if err != nil {
+			t.Errorf("Expected compressed file %s: %s", name, err)
+			continue
+		}
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(gz)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "message="+message) {
+			t.Errorf("Expected %s to contain %q, got %q", name, message, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "billing", "billing-2017-06-01.1.log")); !os.IsNotExist(err) {
+		t.Error("Expected the uncompressed rotated file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "billing", "billing-2017-06-01.log")); err != nil {
+		t.Errorf("Expected the current file to remain: %s", err)
+	}
+}
+
+func TestFileHandlerPrunesExpiredFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filehandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serviceDir := filepath.Join(dir, "billing")
+	os.MkdirAll(serviceDir, 0755)
+	old := filepath.Join(serviceDir, "billing-2017-05-01.1.log.gz")
+	ioutil.WriteFile(old, []byte("old"), 0644)
+	longAgo := time.Now().Add(-72 * time.Hour)
+	os.Chtimes(old, longAgo, longAgo)
+	unrelated := filepath.Join(serviceDir, "notes.txt")
+	ioutil.WriteFile(unrelated, []byte("keep"), 0644)
+	os.Chtimes(unrelated, longAgo, longAgo)
+
+	handler, err := NewFileHandler(FileHandlerOptions{Dir: dir, Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "one", day, nil))
+	handler.HandleLog(testEntry("billing", log.InfoLevel, "two", day.Add(48*time.Hour), nil))
+	handler.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Expected the expired file to be removed")
+	}
+	if _, err := os.Stat(unrelated); err != nil {
+		t.Error("Expected unrelated files to be kept")
+	}
+	if _, err := os.Stat(filepath.Join(serviceDir, "billing-2017-06-01.1.log")); err != nil {
+		t.Errorf("Expected the freshly rotated file to be kept: %s", err)
+	}
+}
+
+func TestFileHandlerPrunesWhenCreated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filehandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serviceDir := filepath.Join(dir, "quiet")
+	os.MkdirAll(serviceDir, 0755)
+	old := filepath.Join(serviceDir, "quiet-2017-05-01.1.log")
+	ioutil.WriteFile(old, []byte("old"), 0644)
+	longAgo := time.Now().Add(-72 * time.Hour)
+	os.Chtimes(old, longAgo, longAgo)
+
+	handler, err := NewFileHandler(FileHandlerOptions{Dir: dir, Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("Expected the expired file of a quiet service to be removed")
+	}
+}
+
+func TestIsRotatedFile(t *testing.T) {
+	cases := map[string]bool{
+		"billing-2017-06-01.log":       false,
+		"billing-2017-06-01.1.log":     true,
+		"billing-2017-06-01.12.log.gz": true,
+		"billing-2017-06-01.x.log":     false,
+		"notes.txt":                    false,
+	}
+	for name, expected := range cases {
+		if isRotatedFile(name) != expected {
+			t.Errorf("isRotatedFile(%q): expected %t", name, expected)
+		}
+	}
+}
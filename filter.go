@@ -0,0 +1,91 @@
+package apexovernsq
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// MatchMessage returns a MatchFunc that matches entries whose message
+// matches the provided regular expression.
+func MatchMessage(re *regexp.Regexp) MatchFunc {
+	return func(e *log.Entry) bool {
+		return re.MatchString(e.Message)
+	}
+}
+
+// MatchFieldRegexp returns a MatchFunc that matches entries that have
+// a field called name whose value matches the provided regular
+// expression.  Field values that aren't strings are matched using
+// their default string representation, as in MatchField.
+func MatchFieldRegexp(name string, re *regexp.Regexp) MatchFunc {
+	return func(e *log.Entry) bool {
+		field, ok := fieldString(e, name)
+		return ok && re.MatchString(field)
+	}
+}
+
+// ParseFieldPredicate turns a predicate on a field, as typically
+// given on a command line, into a MatchFunc.  The predicate takes one
+// of three forms:
+//
+//	key=value   the field is present and equal to value
+//	key!=value  the field is absent or not equal to value
+//	key~=regex  the field is present and matches the regular expression
+func ParseFieldPredicate(predicate string) (MatchFunc, error) {
+	equals := strings.Index(predicate, "=")
+	if equals < 1 {
+		return nil, fmt.Errorf("invalid field predicate %q: expected key=value, key!=value or key~=regex", predicate)
+	}
+	key, value := predicate[:equals], predicate[equals+1:]
+	switch {
+	case strings.HasSuffix(key, "!"):
+		key = strings.TrimSuffix(key, "!")
+		if key == "" {
+			break
+		}
+		return MatchNot(MatchField(key, value)), nil
+	case strings.HasSuffix(key, "~"):
+		key = strings.TrimSuffix(key, "~")
+		if key == "" {
+			break
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field predicate %q: %s", predicate, err)
+		}
+		return MatchFieldRegexp(key, re), nil
+	default:
+		return MatchField(key, value), nil
+	}
+	return nil, fmt.Errorf("invalid field predicate %q: expected key=value, key!=value or key~=regex", predicate)
+}
+
+// FilterHandler is a github.com/apex/log.Handler that only passes on
+// the entries selected by a MatchFunc.  Build the MatchFunc from the
+// Match functions, and ParseFieldPredicate, to combine several
+// conditions.
+type FilterHandler struct {
+	handler log.Handler
+	match   MatchFunc
+}
+
+// NewFilterHandler returns a pointer to a FilterHandler that passes
+// entries matched by match to the provided handler.
+func NewFilterHandler(handler log.Handler, match MatchFunc) *FilterHandler {
+	return &FilterHandler{
+		handler: handler,
+		match:   match,
+	}
+}
+
+// HandleLog makes FilterHandler fulfil the interface required by
+// github.com/apex/log for handlers.
+func (h *FilterHandler) HandleLog(e *log.Entry) error {
+	if !h.match(e) {
+		return nil
+	}
+	return h.handler.HandleLog(e)
+}
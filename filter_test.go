@@ -0,0 +1,72 @@
+package apexovernsq
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestParseFieldPredicate(t *testing.T) {
+	entry := &log.Entry{
+		Message: "payment declined",
+		Fields: log.Fields{
+			"customer_id": "123",
+			"count":       3,
+			"url":         "/pay?x=y",
+		},
+	}
+
+	var caseTable = []struct {
+		predicate string
+		expected  bool
+	}{
+		{"customer_id=123", true},
+		{"customer_id=456", false},
+		{"count=3", true},
+		{"customer_id!=456", true},
+		{"customer_id!=123", false},
+		{"user!=bob", true},
+		{"customer_id~=^1", true},
+		{"customer_id~=^4", false},
+		{"user~=.*", false},
+		{"url=/pay?x=y", true},
+		{"user=", false},
+	}
+
+	for _, testCase := range caseTable {
+		match, err := ParseFieldPredicate(testCase.predicate)
+		if err != nil {
+			t.Errorf("[%s] Unexpected error: %s", testCase.predicate, err)
+			continue
+		}
+		if result := match(entry); result != testCase.expected {
+			t.Errorf("[%s] Expected %t, got %t", testCase.predicate, testCase.expected, result)
+		}
+	}
+}
+
+func TestParseFieldPredicateErrors(t *testing.T) {
+	for _, predicate := range []string{"customer_id", "=123", "!=123", "~=1", "customer_id~=("} {
+		if _, err := ParseFieldPredicate(predicate); err == nil {
+			t.Errorf("[%s] Expected an error", predicate)
+		}
+	}
+}
+
+func TestFilterHandler(t *testing.T) {
+	mem := memory.New()
+	handler := NewFilterHandler(mem, MatchAll(
+		MatchMinLevel(log.WarnLevel),
+		MatchMessage(regexp.MustCompile("declined")),
+	))
+
+	handler.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "payment declined"})
+	handler.HandleLog(&log.Entry{Level: log.InfoLevel, Message: "payment declined"})
+	handler.HandleLog(&log.Entry{Level: log.ErrorLevel, Message: "payment accepted"})
+
+	if len(mem.Entries) != 1 || mem.Entries[0].Level != log.ErrorLevel || mem.Entries[0].Message != "payment declined" {
+		t.Errorf("Expected only the declined error to be passed on, got %+v", mem.Entries)
+	}
+}
@@ -0,0 +1,128 @@
+package apexovernsq
+
+import (
+	"sync"
+
+	"github.com/apex/log"
+)
+
+// fieldLevel is a minimum level that applies to entries with a
+// particular field value.
+type fieldLevel struct {
+	name  string
+	value string
+	level log.Level
+}
+
+// LevelOverrideHandler is a github.com/apex/log.Handler that drops
+// entries below a minimum level, where the minimum can be overridden
+// for particular services, or for entries carrying particular field
+// values.  This makes it possible to, for example, pass on debug
+// entries from one service whilst only passing on warnings from the
+// rest.
+//
+// It works equally well on either side of NSQ.  Wrapping an
+// ApexLogNSQHandler with it avoids publishing entries that nobody
+// wants, whilst wrapping the handler passed to NewNSQApexLogHandler
+// filters entries as they're consumed.  In both cases the level of
+// the github.com/apex/log.Logger in use must be low enough to let the
+// entries reach the LevelOverrideHandler in the first place, so it
+// will usually be set to github.com/apex/log.DebugLevel.
+type LevelOverrideHandler struct {
+	mu           sync.RWMutex
+	handler      log.Handler
+	defaultLevel log.Level
+	services     map[string]log.Level
+	fields       []fieldLevel
+}
+
+// NewLevelOverrideHandler returns a pointer to a LevelOverrideHandler
+// that passes entries at or above defaultLevel to the provided
+// handler, until overrides are added.
+func NewLevelOverrideHandler(handler log.Handler, defaultLevel log.Level) *LevelOverrideHandler {
+	return &LevelOverrideHandler{
+		handler:      handler,
+		defaultLevel: defaultLevel,
+		services:     make(map[string]log.Level),
+	}
+}
+
+// SetDefaultLevel changes the minimum level applied to entries that
+// no override applies to.
+func (h *LevelOverrideHandler) SetDefaultLevel(level log.Level) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.defaultLevel = level
+}
+
+// SetServiceLevel sets the minimum level for entries whose "service"
+// field is service.
+func (h *LevelOverrideHandler) SetServiceLevel(service string, level log.Level) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.services[service] = level
+}
+
+// ClearServiceLevel removes the override for service, if there is
+// one.
+func (h *LevelOverrideHandler) ClearServiceLevel(service string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.services, service)
+}
+
+// SetFieldLevel sets the minimum level for entries that have a field
+// called name with the given value.  Field overrides take precedence
+// over service overrides.  When more than one field override applies
+// to an entry, the one that was set first wins.
+func (h *LevelOverrideHandler) SetFieldLevel(name, value string, level log.Level) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, override := range h.fields {
+		if override.name == name && override.value == value {
+			h.fields[i].level = level
+			return
+		}
+	}
+	h.fields = append(h.fields, fieldLevel{name: name, value: value, level: level})
+}
+
+// ClearFieldLevel removes the override for the field called name
+// with the given value, if there is one.
+func (h *LevelOverrideHandler) ClearFieldLevel(name, value string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, override := range h.fields {
+		if override.name == name && override.value == value {
+			h.fields = append(h.fields[:i], h.fields[i+1:]...)
+			return
+		}
+	}
+}
+
+// Level returns the minimum level that applies to the entry.
+func (h *LevelOverrideHandler) Level(e *log.Entry) log.Level {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, override := range h.fields {
+		if value, ok := fieldString(e, override.name); ok && value == override.value {
+			return override.level
+		}
+	}
+	if service, ok := e.Fields.Get("service").(string); ok {
+		if level, ok := h.services[service]; ok {
+			return level
+		}
+	}
+	return h.defaultLevel
+}
+
+// HandleLog makes LevelOverrideHandler fulfil the interface required
+// by github.com/apex/log for handlers.
+func (h *LevelOverrideHandler) HandleLog(e *log.Entry) error {
+	if e.Level < h.Level(e) {
+		return nil
+	}
+	return h.handler.HandleLog(e)
+}
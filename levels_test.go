@@ -0,0 +1,59 @@
+package apexovernsq
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestLevelOverrideHandlerLevel(t *testing.T) {
+	handler := NewLevelOverrideHandler(memory.New(), log.WarnLevel)
+	handler.SetServiceLevel("checkout", log.DebugLevel)
+	handler.SetFieldLevel("customer_id", "123", log.InfoLevel)
+
+	var caseTable = []struct {
+		fields   log.Fields
+		expected log.Level
+	}{
+		{log.Fields{"service": "billing"}, log.WarnLevel},
+		{log.Fields{"service": "checkout"}, log.DebugLevel},
+		{log.Fields{"service": "checkout", "customer_id": "123"}, log.InfoLevel},
+		{log.Fields{"customer_id": "456"}, log.WarnLevel},
+	}
+	for caseNum, testCase := range caseTable {
+		if level := handler.Level(&log.Entry{Fields: testCase.fields}); level != testCase.expected {
+			t.Errorf("[Case %d] Expected %s, got %s", caseNum, testCase.expected, level)
+		}
+	}
+
+	handler.ClearServiceLevel("checkout")
+	handler.ClearFieldLevel("customer_id", "123")
+	if level := handler.Level(&log.Entry{Fields: log.Fields{"service": "checkout", "customer_id": "123"}}); level != log.WarnLevel {
+		t.Errorf("Expected overrides to be cleared, got %s", level)
+	}
+}
+
+// On the producer side, only entries that pass the overrides should
+// be published.
+func TestLevelOverrideHandlerBeforePublishing(t *testing.T) {
+	var published int
+	fakePublish := func(topic string, body []byte) error {
+		published++
+		return nil
+	}
+	fakeMarshal := func(x interface{}) ([]byte, error) {
+		return nil, nil
+	}
+	handler := NewLevelOverrideHandler(NewApexLogNSQHandler(fakeMarshal, fakePublish, "testing"), log.WarnLevel)
+	handler.SetServiceLevel("checkout", log.DebugLevel)
+	logger := &log.Logger{Handler: handler, Level: log.DebugLevel}
+
+	logger.WithField("service", "checkout").Debug("published")
+	logger.WithField("service", "billing").Debug("dropped")
+	logger.WithField("service", "billing").Warn("published")
+
+	if published != 2 {
+		t.Errorf("Expected 2 entries to be published, got %d", published)
+	}
+}
@@ -0,0 +1,120 @@
+package apexovernsq
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+)
+
+// MatchFunc is a function signature for any function that decides
+// whether a github.com/apex/log.Entry is of interest.  MatchFuncs are
+// used to build routing and filtering rules for log handlers.
+type MatchFunc func(e *log.Entry) bool
+
+// MatchAny returns a MatchFunc that matches every entry.
+func MatchAny() MatchFunc {
+	return func(e *log.Entry) bool {
+		return true
+	}
+}
+
+// MatchMinLevel returns a MatchFunc that matches entries whose level
+// is at least as severe as the provided level.
+func MatchMinLevel(level log.Level) MatchFunc {
+	return func(e *log.Entry) bool {
+		return e.Level >= level
+	}
+}
+
+// MatchLevels returns a MatchFunc that matches entries with exactly
+// one of the provided levels.
+func MatchLevels(levels ...log.Level) MatchFunc {
+	set := make(map[log.Level]bool, len(levels))
+	for _, level := range levels {
+		set[level] = true
+	}
+	return func(e *log.Entry) bool {
+		return set[e.Level]
+	}
+}
+
+// MatchService returns a MatchFunc that matches entries whose
+// "service" field is one of the provided service names.
+func MatchService(services ...string) MatchFunc {
+	set := make(map[string]bool, len(services))
+	for _, service := range services {
+		set[service] = true
+	}
+	return func(e *log.Entry) bool {
+		service, ok := e.Fields.Get("service").(string)
+		return ok && set[service]
+	}
+}
+
+// MatchField returns a MatchFunc that matches entries that have a
+// field called name whose value is equal to value.  Field values that
+// aren't strings are compared using their default string
+// representation, so that entries that have passed through NSQ match
+// in the same way as those logged locally.
+func MatchField(name, value string) MatchFunc {
+	return func(e *log.Entry) bool {
+		field, ok := fieldString(e, name)
+		return ok && field == value
+	}
+}
+
+// MatchHasField returns a MatchFunc that matches entries that have a
+// field called name, regardless of its value.
+func MatchHasField(name string) MatchFunc {
+	return func(e *log.Entry) bool {
+		_, ok := e.Fields[name]
+		return ok
+	}
+}
+
+// MatchAll returns a MatchFunc that matches an entry only when all of
+// the provided MatchFuncs match it.  With no arguments it matches
+// every entry.
+func MatchAll(matchers ...MatchFunc) MatchFunc {
+	return func(e *log.Entry) bool {
+		for _, match := range matchers {
+			if !match(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchOneOf returns a MatchFunc that matches an entry when at least
+// one of the provided MatchFuncs matches it.
+func MatchOneOf(matchers ...MatchFunc) MatchFunc {
+	return func(e *log.Entry) bool {
+		for _, match := range matchers {
+			if match(e) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchNot returns a MatchFunc that inverts the provided MatchFunc.
+func MatchNot(match MatchFunc) MatchFunc {
+	return func(e *log.Entry) bool {
+		return !match(e)
+	}
+}
+
+// fieldString returns the value of the named field as a string, and
+// whether the field was present at all.
+func fieldString(e *log.Entry, name string) (string, bool) {
+	value, ok := e.Fields[name]
+	if !ok {
+		return "", false
+	}
+	if str, ok := value.(string); ok {
+		return str, true
+	}
+	return fmt.Sprint(value), true
+}
@@ -0,0 +1,46 @@
+package apexovernsq
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestMatchers(t *testing.T) {
+	entry := &log.Entry{
+		Level: log.WarnLevel,
+		Fields: log.Fields{
+			"service": "billing",
+			"count":   3,
+		},
+	}
+
+	var caseTable = []struct {
+		name     string
+		match    MatchFunc
+		expected bool
+	}{
+		{"any", MatchAny(), true},
+		{"min level met", MatchMinLevel(log.InfoLevel), true},
+		{"min level not met", MatchMinLevel(log.ErrorLevel), false},
+		{"levels", MatchLevels(log.DebugLevel, log.WarnLevel), true},
+		{"levels missing", MatchLevels(log.DebugLevel), false},
+		{"service", MatchService("checkout", "billing"), true},
+		{"other service", MatchService("checkout"), false},
+		{"string field", MatchField("service", "billing"), true},
+		{"non-string field", MatchField("count", "3"), true},
+		{"absent field", MatchField("user", ""), false},
+		{"has field", MatchHasField("count"), true},
+		{"all", MatchAll(MatchAny(), MatchService("billing")), true},
+		{"not all", MatchAll(MatchAny(), MatchService("checkout")), false},
+		{"one of", MatchOneOf(MatchService("checkout"), MatchHasField("count")), true},
+		{"none of", MatchOneOf(), false},
+		{"not", MatchNot(MatchAny()), false},
+	}
+
+	for _, testCase := range caseTable {
+		if result := testCase.match(entry); result != testCase.expected {
+			t.Errorf("[%s] Expected %t, got %t", testCase.name, testCase.expected, result)
+		}
+	}
+}
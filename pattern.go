@@ -0,0 +1,63 @@
+package apexovernsq
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RegexpPatternPrefix marks a pattern as a regular expression rather
+// than a glob.  For example "re:^billing-(eu|us)$".
+const RegexpPatternPrefix = "re:"
+
+// CompilePattern turns a pattern into a function that reports
+// whether a string matches it.  Patterns starting with
+// RegexpPatternPrefix are regular expressions, as accepted by
+// regexp.Compile, and match if they match any part of the string.
+// All other patterns are globs, as accepted by path.Match, and must
+// match the whole string.  A pattern with no glob metacharacters
+// therefore matches only an identical string.
+func CompilePattern(pattern string) (func(string) bool, error) {
+	if strings.HasPrefix(pattern, RegexpPatternPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, RegexpPatternPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+	return func(s string) bool {
+		matched, _ := path.Match(pattern, s)
+		return matched
+	}, nil
+}
+
+// patternList is a compiled list of patterns.
+type patternList []func(string) bool
+
+// compilePatterns compiles each of the patterns, returning the first
+// error encountered.
+func compilePatterns(patterns []string) (patternList, error) {
+	list := make(patternList, 0, len(patterns))
+	for _, pattern := range patterns {
+		match, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, match)
+	}
+	return list, nil
+}
+
+// matches reports whether s matches any pattern in the list.
+func (l patternList) matches(s string) bool {
+	for _, match := range l {
+		if match(s) {
+			return true
+		}
+	}
+	return false
+}
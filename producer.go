@@ -41,6 +41,7 @@ type ApexLogNSQHandler struct {
 	marshalFunc MarshalFunc
 	publishFunc PublishFunc
 	topic       string
+	stamper     *entryStamper
 }
 
 // NewApexLogNSQHandler returns a pointer to an apexovernsq.ApexLogNSQHandler that can
@@ -58,11 +59,16 @@ type ApexLogNSQHandler struct {
 // The topic is a string determining the nsq topic the messages will
 // be published to.
 //
+// Every entry published is given a unique ID in its EntryIDField, so
+// that consumers can recognise entries that NSQ delivers more than
+// once.
+//
 func NewApexLogNSQHandler(marshalFunc MarshalFunc, publishFunc PublishFunc, topic string) *ApexLogNSQHandler {
 	return &ApexLogNSQHandler{
 		marshalFunc: marshalFunc,
 		publishFunc: publishFunc,
 		topic:       topic,
+		stamper:     newEntryStamper(),
 	}
 }
 
@@ -74,7 +80,7 @@ func (h *ApexLogNSQHandler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	payload, err := h.marshalFunc(e)
+	payload, err := h.marshalFunc(h.stamper.stamp(e))
 	if err != nil {
 		return err
 	}
@@ -93,6 +99,7 @@ type AsyncApexLogNSQHandler struct {
 	wg       sync.WaitGroup
 	logChan  chan *log.Entry
 	stopChan chan bool
+	stamper  *entryStamper
 }
 
 // NewAsyncApexLogNSQHandler returns a pointer to an
@@ -113,6 +120,9 @@ type AsyncApexLogNSQHandler struct {
 // The topic is a string determining the nsq topic the messages will
 // be published to.
 //
+// As with ApexLogNSQHandler, every entry published is given a unique
+// ID in its EntryIDField.
+//
 func NewAsyncApexLogNSQHandler(marshalFunc MarshalFunc, publishFunc PublishFunc, topic string, bufferSize int) *AsyncApexLogNSQHandler {
 	logChan := make(chan *log.Entry, bufferSize)
 	stopChan := make(chan bool, 1)
@@ -120,6 +130,7 @@ func NewAsyncApexLogNSQHandler(marshalFunc MarshalFunc, publishFunc PublishFunc,
 	handler := &AsyncApexLogNSQHandler{
 		logChan:  logChan,
 		stopChan: stopChan,
+		stamper:  newEntryStamper(),
 	}
 
 	// Form a closure over topic and publishFunc to keep the interface clean
@@ -166,6 +177,7 @@ func (h *AsyncApexLogNSQHandler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	e = h.stamper.stamp(e)
 	select {
 	case h.logChan <- e:
 	default:
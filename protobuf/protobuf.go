@@ -2,6 +2,7 @@ package protobuf
 
 import (
 	"fmt"
+	"strconv"
 
 	alog "github.com/apex/log"
 	proto "github.com/golang/protobuf/proto"
@@ -11,8 +12,9 @@ import (
 // with this handler.  Although it accepts an empty interface type, it
 // will only work with an apex.log.Entry type, and will return an
 // error if any other type is passed in.  Not that this mechanism also
-// enforces the rule that any fields set must either be strings or
-// satisfy the fmt.Stringer interface.
+// enforces the rule that any fields set must either be strings,
+// booleans or numbers, or satisfy the fmt.Stringer interface.
+// Booleans and numbers are sent in their usual text form.
 func Marshal(x interface{}) ([]byte, error) {
 	var logEntry *alog.Entry
 	var timestamp []byte
@@ -41,6 +43,10 @@ func Marshal(x interface{}) ([]byte, error) {
 			fields[key] = str
 			continue
 		}
+		if str, ok = scalarString(value); ok {
+			fields[key] = str
+			continue
+		}
 		if stringer, ok = value.(fmt.Stringer); !ok {
 			return nil, fmt.Errorf("Value for field %s is not a string, nor does it satisfy fmt.Stringer", key)
 		}
@@ -55,6 +61,22 @@ func Marshal(x interface{}) ([]byte, error) {
 	return proto.Marshal(entry)
 }
 
+// scalarString returns the text form of a boolean or numeric value,
+// as the logfmt and JSON handlers would show it.
+func scalarString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case bool:
+		return strconv.FormatBool(v), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	}
+	return "", false
+}
+
 // Unmarshal is an implementation of a UnmarshalFunc specifically for
 // unmarshalling an Entry back into apex.log.Entry.
 func Unmarshal(data []byte, v interface{}) error {
@@ -85,3 +85,33 @@ func TestMarshalAndUnmarshalWithError(t *testing.T) {
 	}
 
 }
+
+func TestMarshalScalarFields(t *testing.T) {
+	entry := &alog.Entry{
+		Level: alog.InfoLevel,
+		Fields: alog.Fields{
+			"ok":    true,
+			"count": int64(42),
+			"ratio": 0.5,
+		},
+	}
+	marshalled, err := Marshal(entry)
+	if err != nil {
+		t.Fatalf("Error marshalling: %s", err.Error())
+	}
+	logEntry := &alog.Entry{}
+	if err := Unmarshal(marshalled, logEntry); err != nil {
+		t.Fatalf("Error unmarshalling: %s", err.Error())
+	}
+	expected := map[string]string{"ok": "true", "count": "42", "ratio": "0.5"}
+	for key, value := range expected {
+		if logEntry.Fields.Get(key) != value {
+			t.Errorf("Expected %s=%q, got %s=%v", key, value, key, logEntry.Fields.Get(key))
+		}
+	}
+
+	entry.Fields["unsupported"] = struct{}{}
+	if _, err := Marshal(entry); err == nil {
+		t.Error("Expected an error for a field that is neither a scalar nor a fmt.Stringer")
+	}
+}
@@ -0,0 +1,322 @@
+package apexovernsq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// FilterConfig describes the entries that a ReloadableFilterHandler
+// passes on.  It is designed to be read from JSON, so that it can be
+// kept in a file or sent to an admin endpoint.
+type FilterConfig struct {
+	// Services, Hostnames and Pids, and their Exclude
+	// counterparts, select entries exactly as they do in a
+	// ServiceFilter.
+	Services         []string `json:"services,omitempty"`
+	ExcludeServices  []string `json:"exclude_services,omitempty"`
+	Hostnames        []string `json:"hostnames,omitempty"`
+	ExcludeHostnames []string `json:"exclude_hostnames,omitempty"`
+	Pids             []string `json:"pids,omitempty"`
+	ExcludePids      []string `json:"exclude_pids,omitempty"`
+	// MinLevel, when set, is the least severe level passed on.
+	MinLevel string `json:"min_level,omitempty"`
+	// Levels, when set, lists the only levels passed on.
+	Levels []string `json:"levels,omitempty"`
+	// Fields maps field names to patterns that the field's value
+	// must match.  Entries lacking the field are not passed on.
+	Fields map[string]string `json:"fields,omitempty"`
+	// ExcludeFields maps field names to patterns that the field's
+	// value must not match.
+	ExcludeFields map[string]string `json:"exclude_fields,omitempty"`
+}
+
+// compile validates the FilterConfig and turns it into a MatchFunc.
+// Unlike ServiceFilterApexLogHandler, entries without a service name
+// are treated like any other.
+func (c FilterConfig) compile() (MatchFunc, error) {
+	serviceMatch, err := MatchServiceFilter(ServiceFilter{
+		Services:         c.Services,
+		ExcludeServices:  c.ExcludeServices,
+		Hostnames:        c.Hostnames,
+		ExcludeHostnames: c.ExcludeHostnames,
+		Pids:             c.Pids,
+		ExcludePids:      c.ExcludePids,
+	})
+	if err != nil {
+		return nil, err
+	}
+	matchers := []MatchFunc{serviceMatch}
+
+	if c.MinLevel != "" {
+		level, err := log.ParseLevel(c.MinLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_level %q", c.MinLevel)
+		}
+		matchers = append(matchers, MatchMinLevel(level))
+	}
+	if len(c.Levels) > 0 {
+		levels := make([]log.Level, 0, len(c.Levels))
+		for _, name := range c.Levels {
+			level, err := log.ParseLevel(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid level %q", name)
+			}
+			levels = append(levels, level)
+		}
+		matchers = append(matchers, MatchLevels(levels...))
+	}
+
+	for name, pattern := range c.Fields {
+		filter, err := compileFieldPattern(name, pattern, false)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, filter.matches)
+	}
+	for name, pattern := range c.ExcludeFields {
+		filter, err := compileFieldPattern(name, pattern, true)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, filter.matches)
+	}
+
+	return MatchAll(matchers...), nil
+}
+
+// copy returns a copy of the FilterConfig that shares no slices or
+// maps with it.
+func (c FilterConfig) copy() FilterConfig {
+	copyStrings := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		return append([]string(nil), values...)
+	}
+	copyMap := func(values map[string]string) map[string]string {
+		if values == nil {
+			return nil
+		}
+		copied := make(map[string]string, len(values))
+		for key, value := range values {
+			copied[key] = value
+		}
+		return copied
+	}
+	return FilterConfig{
+		Services:         copyStrings(c.Services),
+		ExcludeServices:  copyStrings(c.ExcludeServices),
+		Hostnames:        copyStrings(c.Hostnames),
+		ExcludeHostnames: copyStrings(c.ExcludeHostnames),
+		Pids:             copyStrings(c.Pids),
+		ExcludePids:      copyStrings(c.ExcludePids),
+		MinLevel:         c.MinLevel,
+		Levels:           copyStrings(c.Levels),
+		Fields:           copyMap(c.Fields),
+		ExcludeFields:    copyMap(c.ExcludeFields),
+	}
+}
+
+// compileFieldPattern returns a fieldFilter that includes, or
+// excludes, entries whose named field matches pattern.
+func compileFieldPattern(name, pattern string, exclude bool) (fieldFilter, error) {
+	match, err := CompilePattern(pattern)
+	if err != nil {
+		return fieldFilter{}, fmt.Errorf("field %q: %s", name, err)
+	}
+	if exclude {
+		return fieldFilter{field: name, exclude: patternList{match}}, nil
+	}
+	return fieldFilter{field: name, include: patternList{match}}, nil
+}
+
+// activeFilter is a FilterConfig together with its compiled form.
+type activeFilter struct {
+	config FilterConfig
+	match  MatchFunc
+}
+
+// ReloadableFilterHandler is a github.com/apex/log.Handler that only
+// passes on entries selected by a FilterConfig, where the
+// FilterConfig can be replaced at any time without interrupting the
+// flow of entries.  It's intended for long running consumers, where
+// a noisy service may need silencing without a restart.
+//
+// A new FilterConfig can be applied by calling Update, by loading it
+// from a file with LoadFile, WatchFile or ReloadOnSignal, or by
+// sending it to the handler's ServeHTTP method.  The new
+// FilterConfig is always validated first; an invalid one is rejected
+// and the current one is left in place.
+type ReloadableFilterHandler struct {
+	mu      sync.Mutex
+	handler log.Handler
+	active  atomic.Value
+}
+
+// NewReloadableFilterHandler returns a pointer to a
+// ReloadableFilterHandler that passes the entries selected by config
+// to the provided handler.  An error is returned if config is
+// invalid.
+func NewReloadableFilterHandler(handler log.Handler, config FilterConfig) (*ReloadableFilterHandler, error) {
+	h := &ReloadableFilterHandler{handler: handler}
+	if err := h.Update(config); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Update validates config and, if it is valid, atomically replaces
+// the current FilterConfig with a copy of it.
+func (h *ReloadableFilterHandler) Update(config FilterConfig) error {
+	config = config.copy()
+	match, err := config.compile()
+	if err != nil {
+		return err
+	}
+	h.active.Store(&activeFilter{config: config, match: match})
+	return nil
+}
+
+// Config returns a copy of the FilterConfig currently in use.
+func (h *ReloadableFilterHandler) Config() FilterConfig {
+	return h.active.Load().(*activeFilter).config.copy()
+}
+
+// HandleLog makes ReloadableFilterHandler fulfil the interface
+// required by github.com/apex/log for handlers.
+func (h *ReloadableFilterHandler) HandleLog(e *log.Entry) error {
+	if !h.active.Load().(*activeFilter).match(e) {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.handler.HandleLog(e)
+}
+
+// LoadFile reads a JSON encoded FilterConfig from the file at path
+// and applies it with Update.
+func (h *ReloadableFilterHandler) LoadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var config FilterConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("cannot parse filter config %s: %s", path, err)
+	}
+	return h.Update(config)
+}
+
+// reloadFile applies the FilterConfig in the file at path, reporting
+// any failure via the backup logger.
+func (h *ReloadableFilterHandler) reloadFile(path string) {
+	if err := h.LoadFile(path); err != nil {
+		backupLogger.WithError(err).WithField("path", path).Error("ReloadableFilterHandler could not reload filter config, keeping the current one")
+	}
+}
+
+// stopOnce returns a function that closes stopChan the first time it
+// is called, and does nothing on later calls.
+func stopOnce(stopChan chan bool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopChan) })
+	}
+}
+
+// ReloadOnSignal reloads the FilterConfig from the file at path
+// whenever the process receives one of the provided signals.  If no
+// signals are provided it reloads on SIGHUP.  Calling the returned
+// function stops the reloading; it is safe to call more than once.
+func (h *ReloadableFilterHandler) ReloadOnSignal(path string, signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	sigChan := make(chan os.Signal, 1)
+	stopChan := make(chan bool)
+	signal.Notify(sigChan, signals...)
+	go func() {
+		for {
+			select {
+			case <-sigChan:
+				h.reloadFile(path)
+			case <-stopChan:
+				signal.Stop(sigChan)
+				return
+			}
+		}
+	}()
+	return stopOnce(stopChan)
+}
+
+// WatchFile checks the file at path every interval and reloads the
+// FilterConfig from it whenever its modification time or size
+// changes.  Calling the returned function stops the watching; it is
+// safe to call more than once.
+func (h *ReloadableFilterHandler) WatchFile(path string, interval time.Duration) (stop func()) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	stopChan := make(chan bool)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if info.ModTime().Equal(lastMod) && info.Size() == lastSize {
+					continue
+				}
+				lastMod, lastSize = info.ModTime(), info.Size()
+				h.reloadFile(path)
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+	return stopOnce(stopChan)
+}
+
+// ServeHTTP makes ReloadableFilterHandler a net/http.Handler that
+// can be mounted on an admin endpoint.  A GET request returns the
+// current FilterConfig as JSON.  A PUT or POST request with a JSON
+// encoded FilterConfig as its body replaces the current one, or
+// responds with 400 Bad Request if it is invalid.
+func (h *ReloadableFilterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut, http.MethodPost:
+		var config FilterConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, fmt.Sprintf("cannot parse filter config: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := h.Update(config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Config())
+}
@@ -0,0 +1,183 @@
+package apexovernsq
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestReloadableFilterHandlerUpdate(t *testing.T) {
+	mem := memory.New()
+	handler, err := NewReloadableFilterHandler(mem, FilterConfig{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	noisy := &log.Entry{Level: log.InfoLevel, Fields: log.Fields{"service": "noisy", "customer_id": "123"}}
+	quiet := &log.Entry{Level: log.ErrorLevel, Fields: log.Fields{"service": "quiet"}}
+
+	handler.HandleLog(noisy)
+	handler.HandleLog(quiet)
+	if count := len(mem.Entries); count != 2 {
+		t.Fatalf("Expected an empty config to pass everything, got %d entries", count)
+	}
+
+	err = handler.Update(FilterConfig{ExcludeServices: []string{"noisy"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	handler.HandleLog(noisy)
+	handler.HandleLog(quiet)
+	if count := len(mem.Entries); count != 3 {
+		t.Fatalf("Expected the noisy service to be silenced, got %d entries", count)
+	}
+
+	err = handler.Update(FilterConfig{MinLevel: "loud"})
+	assertErrorMessage(t, err, `invalid min_level "loud"`)
+	if excluded := handler.Config().ExcludeServices; len(excluded) != 1 {
+		t.Errorf("Expected the previous config to remain after a failed update, got %+v", handler.Config())
+	}
+}
+
+func TestReloadableFilterHandlerConfigIsCopied(t *testing.T) {
+	services := []string{"billing"}
+	fields := map[string]string{"customer_id": "123"}
+	handler, err := NewReloadableFilterHandler(memory.New(), FilterConfig{Services: services, Fields: fields})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	services[0] = "checkout"
+	fields["customer_id"] = "456"
+	config := handler.Config()
+	if config.Services[0] != "billing" || config.Fields["customer_id"] != "123" {
+		t.Errorf("Expected changes to the caller's config to be ignored, got %+v", config)
+	}
+
+	config.Services[0] = "checkout"
+	if services := handler.Config().Services; services[0] != "billing" {
+		t.Errorf("Expected changes to the returned config to be ignored, got %+v", services)
+	}
+}
+
+func TestReloadableFilterHandlerStopTwice(t *testing.T) {
+	handler, _ := NewReloadableFilterHandler(memory.New(), FilterConfig{})
+	for _, stop := range []func(){
+		handler.WatchFile("does-not-exist.json", time.Hour),
+		handler.ReloadOnSignal("does-not-exist.json"),
+	} {
+		stop()
+		stop()
+	}
+}
+
+func TestFilterConfigCompile(t *testing.T) {
+	entry := &log.Entry{Level: log.WarnLevel, Fields: log.Fields{"service": "checkout", "customer_id": "123"}}
+
+	var caseTable = []struct {
+		config   FilterConfig
+		expected bool
+	}{
+		{FilterConfig{MinLevel: "warn"}, true},
+		{FilterConfig{MinLevel: "error"}, false},
+		{FilterConfig{Levels: []string{"debug", "warn"}}, true},
+		{FilterConfig{Levels: []string{"info"}}, false},
+		{FilterConfig{Fields: map[string]string{"customer_id": "12*"}}, true},
+		{FilterConfig{Fields: map[string]string{"order_id": "*"}}, false},
+		{FilterConfig{ExcludeFields: map[string]string{"customer_id": "re:^1"}}, false},
+		{FilterConfig{Services: []string{"checkout"}, ExcludeFields: map[string]string{"order_id": "*"}}, true},
+	}
+	for caseNum, testCase := range caseTable {
+		match, err := testCase.config.compile()
+		if err != nil {
+			t.Fatalf("[Case %d] Unexpected error: %s", caseNum, err)
+		}
+		if result := match(entry); result != testCase.expected {
+			t.Errorf("[Case %d] Expected %t, got %t", caseNum, testCase.expected, result)
+		}
+	}
+}
+
+func writeFilterConfig(t *testing.T, path string, config FilterConfig) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
+
+func TestReloadableFilterHandlerFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apexovernsq")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.json")
+
+	handler, _ := NewReloadableFilterHandler(memory.New(), FilterConfig{})
+	writeFilterConfig(t, path, FilterConfig{Services: []string{"billing"}})
+	if err := handler.LoadFile(path); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if services := handler.Config().Services; len(services) != 1 || services[0] != "billing" {
+		t.Fatalf("Expected config to be loaded, got %+v", handler.Config())
+	}
+
+	stop := handler.WatchFile(path, 5*time.Millisecond)
+	defer stop()
+	writeFilterConfig(t, path, FilterConfig{Services: []string{"checkout", "billing"}})
+	timeout := time.After(time.Second)
+	for len(handler.Config().Services) != 2 {
+		select {
+		case <-timeout:
+			t.Fatal("Expected the changed file to be reloaded within 1 second")
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestReloadableFilterHandlerServeHTTP(t *testing.T) {
+	handler, _ := NewReloadableFilterHandler(memory.New(), FilterConfig{})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	response, err := http.Post(server.URL, "application/json", strings.NewReader(`{"levels": ["wrong"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid config, got %d", response.StatusCode)
+	}
+
+	response, err = http.Post(server.URL, "application/json", strings.NewReader(`{"exclude_services": ["noisy"]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", response.StatusCode)
+	}
+
+	response, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer response.Body.Close()
+	var config FilterConfig
+	if err := json.NewDecoder(response.Body).Decode(&config); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(config.ExcludeServices) != 1 || config.ExcludeServices[0] != "noisy" {
+		t.Errorf("Expected the posted config to be returned, got %+v", config)
+	}
+}
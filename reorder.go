@@ -0,0 +1,198 @@
+package apexovernsq
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// LateField is the name of the field a ReorderHandler sets on entries
+// that arrive too late to be emitted in order, when using the
+// EmitLate policy.
+const LateField = "late"
+
+// LatePolicy determines what a ReorderHandler does with entries that
+// arrive after entries with later timestamps have been emitted.
+type LatePolicy int
+
+const (
+	// EmitLate passes late entries on immediately, with the
+	// LateField set to "true".
+	EmitLate LatePolicy = iota
+	// DropLate discards late entries.
+	DropLate
+)
+
+// entryHeap is a container/heap.Interface of entries ordered by their
+// Timestamp.  Entries with equal timestamps are kept in the order
+// they arrived.
+type entryHeap struct {
+	entries []*log.Entry
+	arrival []uint64
+	next    uint64
+}
+
+func (h *entryHeap) Len() int { return len(h.entries) }
+
+func (h *entryHeap) Less(i, j int) bool {
+	if h.entries[i].Timestamp.Equal(h.entries[j].Timestamp) {
+		return h.arrival[i] < h.arrival[j]
+	}
+	return h.entries[i].Timestamp.Before(h.entries[j].Timestamp)
+}
+
+func (h *entryHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.arrival[i], h.arrival[j] = h.arrival[j], h.arrival[i]
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	h.entries = append(h.entries, x.(*log.Entry))
+	h.arrival = append(h.arrival, h.next)
+	h.next++
+}
+
+func (h *entryHeap) Pop() interface{} {
+	last := len(h.entries) - 1
+	e := h.entries[last]
+	h.entries[last] = nil
+	h.entries = h.entries[:last]
+	h.arrival = h.arrival[:last]
+	return e
+}
+
+// ReorderHandler is a github.com/apex/log.Handler that holds entries
+// back for a fixed delay and then passes them on in Timestamp order.
+// Entries from many producers, arriving via many nsqd instances, are
+// interleaved and out of order; the ReorderHandler restores their
+// order, as long as they arrive within the delay.
+type ReorderHandler struct {
+	mu          sync.Mutex
+	wg          sync.WaitGroup
+	handler     log.Handler
+	delay       time.Duration
+	maxBuffered int
+	policy      LatePolicy
+	buffer      *entryHeap
+	watermark   time.Time
+	stopChan    chan bool
+	now         func() time.Time
+}
+
+// NewReorderHandler returns a pointer to a ReorderHandler that
+// passes entries to the provided handler once they are older than
+// delay.  The ReorderHandler uses a goroutine to release entries as
+// they come of age, so Stop should be called when it's no longer
+// required.
+//
+// At most maxBuffered entries are held at once.  When the buffer is
+// full the oldest entry is released early to make room.
+//
+// Once an entry has been released, any entry with an earlier
+// Timestamp that arrives is late, and is dealt with according to the
+// policy.
+func NewReorderHandler(handler log.Handler, delay time.Duration, maxBuffered int, policy LatePolicy) *ReorderHandler {
+	if maxBuffered < 1 {
+		maxBuffered = 1
+	}
+	h := &ReorderHandler{
+		handler:     handler,
+		delay:       delay,
+		maxBuffered: maxBuffered,
+		policy:      policy,
+		buffer:      &entryHeap{},
+		stopChan:    make(chan bool, 1),
+		now:         time.Now,
+	}
+
+	interval := delay / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.mu.Lock()
+				err := h.release(h.now().Add(-h.delay))
+				h.mu.Unlock()
+				if err != nil {
+					backupLogger.WithError(err).Error("ReorderHandler could not pass on log entry")
+				}
+			case <-h.stopChan:
+				return
+			}
+		}
+	}()
+	return h
+}
+
+// emit passes an entry on to the wrapped handler and moves the
+// watermark up to its Timestamp.  It must be called with the mutex
+// held, so that entries are passed on in order.
+func (h *ReorderHandler) emit(e *log.Entry) error {
+	if e.Timestamp.After(h.watermark) {
+		h.watermark = e.Timestamp
+	}
+	return h.handler.HandleLog(e)
+}
+
+// release passes on every buffered entry with a Timestamp no later
+// than until.  It must be called with the mutex held.
+func (h *ReorderHandler) release(until time.Time) error {
+	var firstErr error
+	for h.buffer.Len() > 0 && !h.buffer.entries[0].Timestamp.After(until) {
+		if err := h.emit(heap.Pop(h.buffer).(*log.Entry)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// HandleLog makes ReorderHandler fulfil the interface required by
+// github.com/apex/log for handlers.
+func (h *ReorderHandler) HandleLog(e *log.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if e.Timestamp.Before(h.watermark) {
+		if h.policy == DropLate {
+			return nil
+		}
+		return h.handler.HandleLog(copyEntryWithFields(e, log.Fields{LateField: "true"}))
+	}
+
+	heap.Push(h.buffer, e)
+	if h.buffer.Len() > h.maxBuffered {
+		return h.emit(heap.Pop(h.buffer).(*log.Entry))
+	}
+	return nil
+}
+
+// Flush immediately passes on every buffered entry, in order.
+func (h *ReorderHandler) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var firstErr error
+	for h.buffer.Len() > 0 {
+		if err := h.emit(heap.Pop(h.buffer).(*log.Entry)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stop halts the goroutine that releases entries and then flushes
+// any entries still being held.
+func (h *ReorderHandler) Stop() error {
+	h.stopChan <- true
+	h.wg.Wait()
+	return h.Flush()
+}
@@ -0,0 +1,107 @@
+package apexovernsq
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func timedEntry(base time.Time, offset int, msg string) *log.Entry {
+	return testEntry("", log.InfoLevel, msg, base.Add(time.Duration(offset)*time.Second), nil)
+}
+
+func entryMessages(entries []*log.Entry) string {
+	var messages []string
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	return strings.Join(messages, ",")
+}
+
+func TestReorderHandlerEmitsInTimestampOrder(t *testing.T) {
+	mem := memory.New()
+	handler := NewReorderHandler(mem, time.Hour, 10, EmitLate)
+	base := time.Now()
+
+	handler.HandleLog(timedEntry(base, 3, "c"))
+	handler.HandleLog(timedEntry(base, 1, "a"))
+	handler.HandleLog(timedEntry(base, 2, "b"))
+	if count := len(mem.Entries); count != 0 {
+		t.Fatalf("Expected entries to be held back, got %d", count)
+	}
+
+	if err := handler.Stop(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if messages := entryMessages(mem.Entries); messages != "a,b,c" {
+		t.Errorf("Expected a,b,c got %s", messages)
+	}
+}
+
+func TestReorderHandlerReleasesAfterDelay(t *testing.T) {
+	mem := memory.New()
+	handler := NewReorderHandler(mem, 20*time.Millisecond, 10, EmitLate)
+	defer handler.Stop()
+	base := time.Now()
+
+	handler.HandleLog(timedEntry(base, 0, "b"))
+	handler.HandleLog(&log.Entry{Message: "a", Timestamp: base.Add(-time.Millisecond), Fields: log.Fields{}})
+
+	timeout := time.After(time.Second)
+	for {
+		handler.mu.Lock()
+		messages := entryMessages(mem.Entries)
+		handler.mu.Unlock()
+		if messages == "a,b" {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("Expected a,b to be released within 1 second, got %q", messages)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestReorderHandlerBoundsBuffer(t *testing.T) {
+	mem := memory.New()
+	handler := NewReorderHandler(mem, time.Hour, 2, EmitLate)
+	defer handler.Stop()
+	base := time.Now()
+
+	handler.HandleLog(timedEntry(base, 2, "b"))
+	handler.HandleLog(timedEntry(base, 3, "c"))
+	handler.HandleLog(timedEntry(base, 1, "a"))
+	if messages := entryMessages(mem.Entries); messages != "a" {
+		t.Errorf("Expected the oldest entry to be released, got %q", messages)
+	}
+}
+
+func TestReorderHandlerLatePolicies(t *testing.T) {
+	base := time.Now()
+	for _, policy := range []LatePolicy{EmitLate, DropLate} {
+		mem := memory.New()
+		handler := NewReorderHandler(mem, time.Hour, 1, policy)
+		handler.HandleLog(timedEntry(base, 2, "b"))
+		handler.HandleLog(timedEntry(base, 3, "c"))
+		handler.HandleLog(timedEntry(base, 1, "a"))
+		handler.Stop()
+
+		switch policy {
+		case EmitLate:
+			if messages := entryMessages(mem.Entries); messages != "b,a,c" {
+				t.Fatalf("Expected b,a,c got %s", messages)
+			}
+			if late := mem.Entries[1].Fields.Get(LateField); late != "true" {
+				t.Errorf("Expected late entry to be flagged, got %v", late)
+			}
+		case DropLate:
+			if messages := entryMessages(mem.Entries); messages != "b,c" {
+				t.Errorf("Expected b,c got %s", messages)
+			}
+		}
+	}
+}
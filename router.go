@@ -0,0 +1,148 @@
+package apexovernsq
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/apex/log"
+)
+
+// RouteMode determines how a RouterHandler dispatches an entry when
+// more than one of its routes match it.
+type RouteMode int
+
+const (
+	// FirstMatch dispatches an entry only to the targets of the
+	// first route that matches it.
+	FirstMatch RouteMode = iota
+	// AllMatches dispatches an entry to the targets of every route
+	// that matches it.
+	AllMatches
+)
+
+// route is a single, ordered rule in a RouterHandler.
+type route struct {
+	match   MatchFunc
+	targets []string
+}
+
+// RouterHandler is a github.com/apex/log.Handler that dispatches
+// entries to one or more named downstream handlers, according to an
+// ordered list of routes.  It's intended to sit between an
+// NSQApexLogHandler and the handlers that finally deal with the
+// entries, so that a single NSQ channel can feed several sinks.
+type RouterHandler struct {
+	mu           sync.RWMutex
+	mode         RouteMode
+	handlers     map[string]log.Handler
+	routes       []route
+	defaultRoute []string
+}
+
+// NewRouterHandler returns a pointer to a RouterHandler that will
+// dispatch to the provided named handlers.
+//
+// The mode determines whether an entry stops at the first route that
+// matches it (FirstMatch), or is sent on by every route that matches
+// it (AllMatches).
+//
+// Routes are added, in order, by calling AddRoute.  An entry that
+// matches no route is sent to the handlers named by SetDefaultRoute,
+// or dropped if no default route has been set.
+func NewRouterHandler(mode RouteMode, handlers map[string]log.Handler) *RouterHandler {
+	named := make(map[string]log.Handler, len(handlers))
+	for name, handler := range handlers {
+		named[name] = handler
+	}
+	return &RouterHandler{
+		mode:     mode,
+		handlers: named,
+	}
+}
+
+// checkTargets returns an error if any of the targets isn't the name
+// of a handler known to the RouterHandler.
+func (h *RouterHandler) checkTargets(targets []string) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("a route must have at least one target")
+	}
+	for _, target := range targets {
+		if _, ok := h.handlers[target]; !ok {
+			return fmt.Errorf("no handler called %q", target)
+		}
+	}
+	return nil
+}
+
+// AddRoute appends a route to the RouterHandler.  Entries for which
+// match returns true will be passed to each of the handlers named in
+// targets.  An error is returned if a target doesn't name a handler
+// provided to NewRouterHandler.
+func (h *RouterHandler) AddRoute(match MatchFunc, targets ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.checkTargets(targets); err != nil {
+		return err
+	}
+	h.routes = append(h.routes, route{match: match, targets: append([]string(nil), targets...)})
+	return nil
+}
+
+// SetDefaultRoute names the handlers that will receive entries that
+// match none of the routes.  Calling SetDefaultRoute with no targets
+// causes such entries to be dropped, which is the default behaviour.
+func (h *RouterHandler) SetDefaultRoute(targets ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(targets) > 0 {
+		if err := h.checkTargets(targets); err != nil {
+			return err
+		}
+	}
+	h.defaultRoute = append([]string(nil), targets...)
+	return nil
+}
+
+// targetsFor returns the names of the handlers an entry should be
+// dispatched to.  No handler is named more than once.
+func (h *RouterHandler) targetsFor(e *log.Entry) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, r := range h.routes {
+		if !r.match(e) {
+			continue
+		}
+		for _, target := range r.targets {
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+		if h.mode == FirstMatch {
+			break
+		}
+	}
+	if len(targets) == 0 {
+		return h.defaultRoute
+	}
+	return targets
+}
+
+// HandleLog makes RouterHandler fulfil the interface required by
+// github.com/apex/log for handlers.  The entry is passed to every
+// handler selected by the routes, even if one of them fails; the
+// first error encountered is returned.
+func (h *RouterHandler) HandleLog(e *log.Entry) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var firstErr error
+	for _, target := range h.targetsFor(e) {
+		if err := h.handlers[target].HandleLog(e); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("handler %q: %s", target, err)
+		}
+	}
+	return firstErr
+}
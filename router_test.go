@@ -0,0 +1,99 @@
+package apexovernsq
+
+import (
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func newTestRouter(t *testing.T, mode RouteMode) (*RouterHandler, map[string]*memory.Handler) {
+	sinks := map[string]*memory.Handler{
+		"alerting": memory.New(),
+		"archive":  memory.New(),
+		"stdout":   memory.New(),
+	}
+	handlers := make(map[string]log.Handler, len(sinks))
+	for name, sink := range sinks {
+		handlers[name] = sink
+	}
+	router := NewRouterHandler(mode, handlers)
+	if err := router.AddRoute(MatchMinLevel(log.ErrorLevel), "alerting"); err != nil {
+		t.Fatalf("Unexpected error adding route: %s", err)
+	}
+	if err := router.AddRoute(MatchHasField("audit"), "archive"); err != nil {
+		t.Fatalf("Unexpected error adding route: %s", err)
+	}
+	return router, sinks
+}
+
+func TestRouterHandlerFirstMatch(t *testing.T) {
+	router, sinks := newTestRouter(t, FirstMatch)
+	router.HandleLog(&log.Entry{Level: log.ErrorLevel, Fields: log.Fields{"audit": "true"}})
+	router.HandleLog(&log.Entry{Level: log.InfoLevel, Fields: log.Fields{"audit": "true"}})
+	router.HandleLog(&log.Entry{Level: log.InfoLevel, Fields: log.Fields{}})
+
+	if count := len(sinks["alerting"].Entries); count != 1 {
+		t.Errorf("Expected 1 alerting entry, got %d", count)
+	}
+	if count := len(sinks["archive"].Entries); count != 1 {
+		t.Errorf("Expected 1 archive entry, got %d", count)
+	}
+	if count := len(sinks["stdout"].Entries); count != 0 {
+		t.Errorf("Expected unmatched entries to be dropped, got %d", count)
+	}
+}
+
+func TestRouterHandlerAllMatchesWithDefault(t *testing.T) {
+	router, sinks := newTestRouter(t, AllMatches)
+	if err := router.SetDefaultRoute("stdout"); err != nil {
+		t.Fatalf("Unexpected error setting default route: %s", err)
+	}
+	router.HandleLog(&log.Entry{Level: log.ErrorLevel, Fields: log.Fields{"audit": "true"}})
+	router.HandleLog(&log.Entry{Level: log.InfoLevel, Fields: log.Fields{}})
+
+	if count := len(sinks["alerting"].Entries); count != 1 {
+		t.Errorf("Expected 1 alerting entry, got %d", count)
+	}
+	if count := len(sinks["archive"].Entries); count != 1 {
+		t.Errorf("Expected 1 archive entry, got %d", count)
+	}
+	if count := len(sinks["stdout"].Entries); count != 1 {
+		t.Errorf("Expected 1 default entry, got %d", count)
+	}
+}
+
+func TestRouterHandlerUnknownTarget(t *testing.T) {
+	router, _ := newTestRouter(t, FirstMatch)
+	err := router.AddRoute(MatchAny(), "nowhere")
+	assertErrorMessage(t, err, `no handler called "nowhere"`)
+	err = router.SetDefaultRoute("nowhere")
+	assertErrorMessage(t, err, `no handler called "nowhere"`)
+}
+
+func TestRouterHandlerCopiesTargets(t *testing.T) {
+	router, sinks := newTestRouter(t, FirstMatch)
+	targets := []string{"stdout"}
+	if err := router.AddRoute(MatchField("user", "bob"), targets...); err != nil {
+		t.Fatalf("Unexpected error adding route: %s", err)
+	}
+	targets[0] = "alerting"
+	router.HandleLog(&log.Entry{Level: log.InfoLevel, Fields: log.Fields{"user": "bob"}})
+
+	if count := len(sinks["stdout"].Entries); count != 1 {
+		t.Errorf("Expected 1 stdout entry, got %d", count)
+	}
+	if count := len(sinks["alerting"].Entries); count != 0 {
+		t.Errorf("Expected changes to the caller's targets to be ignored, got %d alerting entries", count)
+	}
+}
+
+func assertErrorMessage(t *testing.T, err error, expected string) {
+	if err == nil {
+		t.Errorf("Expected error %q, nil returned", expected)
+		return
+	}
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
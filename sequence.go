@@ -0,0 +1,206 @@
+package apexovernsq
+
+import (
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// GapStats holds the counters maintained by a GapDetector.
+type GapStats struct {
+	// Gaps is the number of times a jump in a producer's
+	// sequence has been detected.
+	Gaps uint64 `json:"gaps"`
+	// Missing is the total number of entries skipped over by
+	// those jumps.
+	Missing uint64 `json:"missing"`
+	// Restarts is the number of times a producer from a new
+	// process has appeared for a service and host.
+	Restarts uint64 `json:"restarts"`
+}
+
+// producerExpiry is how long a producer that has been replaced by
+// another for its service and host is remembered after its last
+// entry.
+const producerExpiry = time.Hour
+
+// producerState is what a GapDetector knows about the entries from
+// one producer.
+type producerState struct {
+	key      string
+	lastSeq  uint64
+	lastSeen time.Time
+}
+
+// GapDetector is a github.com/apex/log.Handler that tracks the
+// sequence numbers stamped on entries by the producer handlers and
+// reports when entries appear to have been lost in transit.
+//
+// Each producer's entries are followed separately, by their
+// ProducerIDField.  When a producer's sequence jumps forward a
+// synthetic warning entry is passed to the wrapped handler ahead of
+// the entry that revealed the problem, and the GapStats are updated.
+//
+// A producer from a new process, for a service and host that has been
+// seen before, is reported as a restart, and its sequence is checked
+// from the start.  A second process of a service starting on the same
+// host is reported in the same way, but only once: the entries of
+// producers that have already been seen, including late ones from a
+// process that has since been replaced, are simply checked against
+// their own sequence.  Once a replaced producer has sent nothing for
+// an hour it's forgotten, so that a long running GapDetector doesn't
+// remember every producer it has ever seen.
+//
+// NSQ does not guarantee ordering, so a GapDetector will report
+// entries that arrive late as missing.  Entries that arrive behind
+// their producer's sequence are passed on without further reporting.
+// Wrapping a GapDetector in a ReorderHandler reduces such false
+// reports.
+type GapDetector struct {
+	mu        sync.Mutex
+	handler   log.Handler
+	producers map[string]*producerState
+	latest    map[string]string
+	stats     GapStats
+	now       func() time.Time
+}
+
+// NewGapDetector returns a pointer to a GapDetector that passes
+// entries, and any warnings it generates, to the provided handler.
+func NewGapDetector(handler log.Handler) *GapDetector {
+	return &GapDetector{
+		handler:   handler,
+		producers: make(map[string]*producerState),
+		latest:    make(map[string]string),
+		now:       time.Now,
+	}
+}
+
+// Stats returns a snapshot of the GapDetector's counters.
+func (d *GapDetector) Stats() GapStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// PublishExpvar exposes the GapDetector's counters as an expvar
+// variable with the provided name.  Like expvar.Publish, it panics if
+// the name is already in use.
+func (d *GapDetector) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return d.Stats()
+	}))
+}
+
+// warning builds a synthetic entry describing a problem with the
+// stream that e belongs to.
+func (d *GapDetector) warning(e *log.Entry, msg string, fields log.Fields) *log.Entry {
+	for _, name := range []string{"service", "hostname", ProducerIDField} {
+		if value, ok := e.Fields[name]; ok {
+			fields[name] = value
+		}
+	}
+	return &log.Entry{
+		Logger:    e.Logger,
+		Fields:    fields,
+		Level:     log.WarnLevel,
+		Timestamp: time.Now(),
+		Message:   msg,
+	}
+}
+
+// producerProcess returns the part of a producer ID that identifies
+// the producing process, leaving out the suffix that distinguishes
+// handlers within the process.
+func producerProcess(producerID string) string {
+	if slash := strings.LastIndex(producerID, "/"); slash > 0 {
+		return producerID[:slash]
+	}
+	return producerID
+}
+
+// forget drops the state of producers that have been replaced by
+// another for their service and host, and that have sent nothing for
+// producerExpiry.
+func (d *GapDetector) forget(now time.Time) {
+	for producerID, state := range d.producers {
+		if d.latest[state.key] != producerID && now.Sub(state.lastSeen) >= producerExpiry {
+			delete(d.producers, producerID)
+		}
+	}
+}
+
+// check updates the state of the producer of e and returns any
+// warnings that should precede it.
+func (d *GapDetector) check(e *log.Entry, producerID string, seq uint64) []*log.Entry {
+	var warnings []*log.Entry
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	state, ok := d.producers[producerID]
+	if !ok {
+		d.forget(now)
+
+		service, _ := fieldString(e, "service")
+		hostname, _ := fieldString(e, "hostname")
+		key := service + "@" + hostname
+		previous, seen := d.latest[key]
+		d.latest[key] = producerID
+
+		state = &producerState{key: key, lastSeq: seq, lastSeen: now}
+		d.producers[producerID] = state
+		if !seen || producerProcess(previous) == producerProcess(producerID) {
+			return nil
+		}
+		d.stats.Restarts++
+		warnings = append(warnings, d.warning(e, "log producer restarted", log.Fields{
+			"previous_producer_id": previous,
+			"previous_seq":         strconv.FormatUint(d.producers[previous].lastSeq, 10),
+		}))
+		state.lastSeq = 0
+	}
+	state.lastSeen = now
+
+	if seq <= state.lastSeq {
+		return warnings
+	}
+	if missing := seq - state.lastSeq - 1; missing > 0 {
+		d.stats.Gaps++
+		d.stats.Missing += missing
+		warnings = append(warnings, d.warning(e, "log entries missing from producer sequence", log.Fields{
+			"missing":  strconv.FormatUint(missing, 10),
+			"from_seq": strconv.FormatUint(state.lastSeq+1, 10),
+			"to_seq":   strconv.FormatUint(seq-1, 10),
+		}))
+	}
+	state.lastSeq = seq
+	return warnings
+}
+
+// HandleLog makes GapDetector fulfil the interface required by
+// github.com/apex/log for handlers.  Entries without a producer ID
+// and sequence number are passed on unchecked.
+func (d *GapDetector) HandleLog(e *log.Entry) error {
+	producerID, ok := fieldString(e, ProducerIDField)
+	if !ok {
+		return d.handler.HandleLog(e)
+	}
+	seqString, _ := fieldString(e, SequenceField)
+	seq, err := strconv.ParseUint(seqString, 10, 64)
+	if err != nil {
+		return d.handler.HandleLog(e)
+	}
+
+	for _, warning := range d.check(e, producerID, seq) {
+		if err := d.handler.HandleLog(warning); err != nil {
+			return err
+		}
+	}
+	return d.handler.HandleLog(e)
+}
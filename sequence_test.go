@@ -0,0 +1,130 @@
+package apexovernsq
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestEntryStamperSequence(t *testing.T) {
+	stamper := newEntryStamper()
+	hostname, _ := os.Hostname()
+	prefix := fmt.Sprintf("%s/%d/", hostname, os.Getpid())
+	if !strings.HasPrefix(stamper.producerID, prefix) {
+		t.Errorf("Expected producer ID to start with %q, got %q", prefix, stamper.producerID)
+	}
+
+	for i := 1; i <= 3; i++ {
+		entry := stamper.stamp(&log.Entry{Fields: log.Fields{}})
+		expected := fmt.Sprintf("%d", i)
+		if seq := entry.Fields.Get(SequenceField); seq != expected {
+			t.Errorf("Expected seq=%s, got seq=%v", expected, seq)
+		}
+		if id := entry.Fields.Get(EntryIDField); id != stamper.producerID+"-"+expected {
+			t.Errorf("Unexpected entry ID %v", id)
+		}
+	}
+}
+
+func sequencedEntry(producerID string, seq int) *log.Entry {
+	return testEntry("checkout", log.InfoLevel, fmt.Sprintf("%d", seq), time.Time{}, log.Fields{
+		"hostname":      "web1",
+		ProducerIDField: producerID,
+		SequenceField:   fmt.Sprintf("%d", seq),
+	})
+}
+
+func TestGapDetector(t *testing.T) {
+	mem := memory.New()
+	detector := NewGapDetector(mem)
+
+	detector.HandleLog(sequencedEntry("a", 1))
+	detector.HandleLog(sequencedEntry("a", 2))
+	detector.HandleLog(sequencedEntry("a", 5))
+	detector.HandleLog(sequencedEntry("a", 4))
+	detector.HandleLog(sequencedEntry("b", 2))
+	detector.HandleLog(&log.Entry{Message: "unsequenced", Fields: log.Fields{}})
+
+	stats := detector.Stats()
+	expected := GapStats{Gaps: 2, Missing: 3, Restarts: 1}
+	if stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+
+	var messages []string
+	for _, entry := range mem.Entries {
+		messages = append(messages, entry.Message)
+	}
+	expectedMessages := []string{
+		"1", "2",
+		"log entries missing from producer sequence", "5",
+		"4",
+		"log producer restarted", "log entries missing from producer sequence", "2",
+		"unsequenced",
+	}
+	if strings.Join(messages, "|") != strings.Join(expectedMessages, "|") {
+		t.Fatalf("Expected %q, got %q", expectedMessages, messages)
+	}
+
+	gap := mem.Entries[2]
+	if gap.Level != log.WarnLevel {
+		t.Errorf("Expected a warning, got %s", gap.Level)
+	}
+	if gap.Fields.Get("missing") != "2" || gap.Fields.Get("service") != "checkout" {
+		t.Errorf("Unexpected gap fields %v", gap.Fields)
+	}
+}
+
+func TestGapDetectorForgetsReplacedProducers(t *testing.T) {
+	detector := NewGapDetector(memory.New())
+	now := time.Date(2017, 6, 1, 9, 0, 0, 0, time.UTC)
+	detector.now = func() time.Time { return now }
+
+	detector.HandleLog(sequencedEntry("web1/100/aa", 1))
+	detector.HandleLog(sequencedEntry("web1/200/bb", 1))
+	now = now.Add(producerExpiry)
+	detector.HandleLog(sequencedEntry("web1/200/bb", 2))
+	detector.HandleLog(sequencedEntry("web1/300/cc", 1))
+
+	if count := len(detector.producers); count != 2 {
+		t.Errorf("Expected the first producer to be forgotten, got %d producers", count)
+	}
+	if _, ok := detector.producers["web1/100/aa"]; ok {
+		t.Error("Expected the first producer to be forgotten")
+	}
+	if stats := detector.Stats(); stats.Restarts != 2 {
+		t.Errorf("Expected 2 restarts, got %+v", stats)
+	}
+}
+
+func TestGapDetectorInterleavedProducers(t *testing.T) {
+	mem := memory.New()
+	detector := NewGapDetector(mem)
+
+	// Two processes of one service on one host, the second with two
+	// handlers, publishing at the same time.
+	detector.HandleLog(sequencedEntry("web1/100/aa", 1))
+	detector.HandleLog(sequencedEntry("web1/200/bb", 1))
+	detector.HandleLog(sequencedEntry("web1/200/cc", 1))
+	for seq := 2; seq <= 4; seq++ {
+		detector.HandleLog(sequencedEntry("web1/100/aa", seq))
+		detector.HandleLog(sequencedEntry("web1/200/bb", seq))
+		detector.HandleLog(sequencedEntry("web1/200/cc", seq))
+	}
+	// A late entry from the first process is checked against its
+	// own sequence.
+	detector.HandleLog(sequencedEntry("web1/100/aa", 6))
+
+	expected := GapStats{Gaps: 1, Missing: 1, Restarts: 1}
+	if stats := detector.Stats(); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	if count := len(mem.Entries); count != 15 {
+		t.Errorf("Expected 13 entries and 2 warnings, got %d entries", count)
+	}
+}
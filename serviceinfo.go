@@ -0,0 +1,209 @@
+package apexovernsq
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+)
+
+// ServiceInfo describes the process that is logging.  Its fields are
+// attached to every entry made through a context returned by
+// NewApexLogServiceContext or NewApexLogServiceContextWithHandler.
+//
+// The ServiceInfo is detected once, the first time it is needed, and
+// cached for the life of the process.  Detection draws on, in order of
+// precedence:
+//
+//   - the environment variables SERVICE_NAME, SERVICE_VERSION,
+//     SERVICE_ENVIRONMENT and SERVICE_INSTANCE;
+//   - the build information embedded by the Go toolchain, for the
+//     version, main module and VCS revision, when built with Go 1.18
+//     or later;
+//   - the process itself, for the name, hostname and pid;
+//   - the environment variables POD_NAME, POD_NAMESPACE and NODE_NAME,
+//     which are typically set from the Kubernetes downward API, or
+//     failing those the files "name", "namespace" and "node" in
+//     /etc/podinfo, and the service account namespace;
+//   - /proc/self/cgroup, for a container ID.
+//
+// Any of the detected values can be replaced with SetServiceInfo.
+type ServiceInfo struct {
+	Name        string
+	Version     string
+	Environment string
+	Instance    string
+	Hostname    string
+	Pid         string
+	Module      string
+	Revision    string
+	ContainerID string
+	PodName     string
+	Namespace   string
+	NodeName    string
+}
+
+// Fields makes ServiceInfo implement github.com/apex/log.Fielder.
+// The "service", "hostname" and "pid" fields are always present; the
+// rest are only present when they are known.
+func (s ServiceInfo) Fields() log.Fields {
+	fields := log.Fields{
+		"service":  s.Name,
+		"hostname": s.Hostname,
+		"pid":      s.Pid,
+	}
+	for name, value := range map[string]string{
+		"version":       s.Version,
+		"environment":   s.Environment,
+		"instance":      s.Instance,
+		"module":        s.Module,
+		"revision":      s.Revision,
+		"container_id":  s.ContainerID,
+		"k8s_pod":       s.PodName,
+		"k8s_namespace": s.Namespace,
+		"k8s_node":      s.NodeName,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// merge returns a copy of s with every non-empty field of overrides
+// replacing the corresponding field in s.
+func (s ServiceInfo) merge(overrides ServiceInfo) ServiceInfo {
+	pick := func(current *string, override string) {
+		if override != "" {
+			*current = override
+		}
+	}
+	pick(&s.Name, overrides.Name)
+	pick(&s.Version, overrides.Version)
+	pick(&s.Environment, overrides.Environment)
+	pick(&s.Instance, overrides.Instance)
+	pick(&s.Hostname, overrides.Hostname)
+	pick(&s.Pid, overrides.Pid)
+	pick(&s.Module, overrides.Module)
+	pick(&s.Revision, overrides.Revision)
+	pick(&s.ContainerID, overrides.ContainerID)
+	pick(&s.PodName, overrides.PodName)
+	pick(&s.Namespace, overrides.Namespace)
+	pick(&s.NodeName, overrides.NodeName)
+	return s
+}
+
+var (
+	serviceInfoOnce sync.Once
+	serviceInfoMu   sync.RWMutex
+	serviceInfo     ServiceInfo
+
+	// These locations are variables so that tests can point them
+	// elsewhere.
+	podInfoDir           = "/etc/podinfo"
+	serviceAccountNSFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	cgroupFile           = "/proc/self/cgroup"
+
+	containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+)
+
+// CurrentServiceInfo returns the ServiceInfo attached to service
+// logging contexts, detecting it first if necessary.
+func CurrentServiceInfo() ServiceInfo {
+	serviceInfoOnce.Do(func() {
+		detected := detectServiceInfo()
+		serviceInfoMu.Lock()
+		serviceInfo = detected
+		serviceInfoMu.Unlock()
+	})
+	serviceInfoMu.RLock()
+	defer serviceInfoMu.RUnlock()
+	return serviceInfo
+}
+
+// SetServiceInfo replaces the detected ServiceInfo values with the
+// non-empty fields of overrides.  It affects service logging contexts
+// created after it is called, so it is best called early in main.
+func SetServiceInfo(overrides ServiceInfo) {
+	CurrentServiceInfo()
+	serviceInfoMu.Lock()
+	defer serviceInfoMu.Unlock()
+	serviceInfo = serviceInfo.merge(overrides)
+}
+
+// detectServiceInfo works out the ServiceInfo for this process.
+func detectServiceInfo() ServiceInfo {
+	info := ServiceInfo{
+		Name: processName(),
+		Pid:  fmt.Sprintf("%d", os.Getpid()),
+	}
+	// A failure here leaves the hostname empty; there's nowhere
+	// sensible to report it, as we're setting up logging.
+	info.Hostname, _ = os.Hostname()
+
+	info = info.merge(embeddedServiceInfo(info.Name))
+	info = info.merge(kubernetesServiceInfo(info.Hostname))
+	info.ContainerID = containerID()
+
+	return info.merge(ServiceInfo{
+		Name:        os.Getenv("SERVICE_NAME"),
+		Version:     os.Getenv("SERVICE_VERSION"),
+		Environment: os.Getenv("SERVICE_ENVIRONMENT"),
+		Instance:    os.Getenv("SERVICE_INSTANCE"),
+	})
+}
+
+// readTrimmed returns the trimmed contents of a file, or an empty
+// string if it can't be read.
+func readTrimmed(filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// kubernetesServiceInfo returns the pod identity exposed to the
+// process by Kubernetes, if any.
+func kubernetesServiceInfo(hostname string) ServiceInfo {
+	info := ServiceInfo{
+		PodName:   os.Getenv("POD_NAME"),
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		NodeName:  os.Getenv("NODE_NAME"),
+	}
+	info = info.fillFrom(ServiceInfo{
+		PodName:   readTrimmed(filepath.Join(podInfoDir, "name")),
+		Namespace: readTrimmed(filepath.Join(podInfoDir, "namespace")),
+		NodeName:  readTrimmed(filepath.Join(podInfoDir, "node")),
+	})
+	if info.Namespace == "" {
+		info.Namespace = readTrimmed(serviceAccountNSFile)
+	}
+	// Inside a pod the hostname is the pod name, unless it has
+	// been overridden in the pod spec.
+	if info.PodName == "" && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		info.PodName = hostname
+	}
+	return info
+}
+
+// fillFrom returns a copy of s with its empty fields filled in from
+// fallback.
+func (s ServiceInfo) fillFrom(fallback ServiceInfo) ServiceInfo {
+	return fallback.merge(s)
+}
+
+// containerID returns the ID of the container the process is running
+// in, as recorded in its cgroup, or an empty string.
+func containerID() string {
+	data, err := ioutil.ReadFile(cgroupFile)
+	if err != nil {
+		return ""
+	}
+	return containerIDPattern.FindString(string(data))
+}
@@ -0,0 +1,136 @@
+package apexovernsq
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestServiceInfoFields(t *testing.T) {
+	fields := ServiceInfo{Name: "checkout", Pid: "42", Version: "v1.2.3"}.Fields()
+	expected := log.Fields{"service": "checkout", "hostname": "", "pid": "42", "version": "v1.2.3"}
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, fields)
+	}
+	for name, value := range expected {
+		if fields[name] != value {
+			t.Errorf("Expected %s=%q, got %s=%q", name, value, name, fields[name])
+		}
+	}
+}
+
+// setEnv sets, or with an empty value unsets, environment variables
+// and returns a function that restores their previous values.
+func setEnv(values map[string]string) (restore func()) {
+	saved := make(map[string]*string, len(values))
+	for name, value := range values {
+		if previous, ok := os.LookupEnv(name); ok {
+			saved[name] = &previous
+		} else {
+			saved[name] = nil
+		}
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+	return func() {
+		for name, previous := range saved {
+			if previous == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *previous)
+			}
+		}
+	}
+}
+
+func TestKubernetesServiceInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apexovernsq")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	podInfo := filepath.Join(dir, "podinfo")
+	os.Mkdir(podInfo, 0755)
+	ioutil.WriteFile(filepath.Join(podInfo, "name"), []byte("checkout-7d9f\n"), 0644)
+	ioutil.WriteFile(filepath.Join(podInfo, "namespace"), []byte("shop\n"), 0644)
+	serviceAccountNS := filepath.Join(dir, "serviceaccount-namespace")
+	ioutil.WriteFile(serviceAccountNS, []byte("default\n"), 0644)
+
+	savedDir, savedNSFile := podInfoDir, serviceAccountNSFile
+	podInfoDir, serviceAccountNSFile = podInfo, serviceAccountNS
+	defer func() { podInfoDir, serviceAccountNSFile = savedDir, savedNSFile }()
+	defer setEnv(map[string]string{
+		"POD_NAME":                "",
+		"POD_NAMESPACE":           "",
+		"NODE_NAME":               "node-3",
+		"KUBERNETES_SERVICE_HOST": "",
+	})()
+
+	info := kubernetesServiceInfo("host")
+	expected := ServiceInfo{PodName: "checkout-7d9f", Namespace: "shop", NodeName: "node-3"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+
+	// Without the downward API files, the namespace comes from the
+	// service account and the pod name from the hostname.
+	podInfoDir = filepath.Join(dir, "missing")
+	os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	info = kubernetesServiceInfo("checkout-5b2c")
+	expected = ServiceInfo{PodName: "checkout-5b2c", Namespace: "default", NodeName: "node-3"}
+	if info != expected {
+		t.Errorf("Expected %+v, got %+v", expected, info)
+	}
+
+	os.Setenv("POD_NAMESPACE", "payments")
+	if info = kubernetesServiceInfo("host"); info.Namespace != "payments" {
+		t.Errorf("Expected POD_NAMESPACE to take precedence, got %+v", info)
+	}
+}
+
+func TestContainerID(t *testing.T) {
+	file, err := ioutil.TempFile("", "cgroup")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.Remove(file.Name())
+	id := "4f7a2a5b6f5b0c56b9a8c3b1a4e6a4c9e8d5f1c2b3a4d5e6f7a8b9c0d1e2f3a4"
+	file.WriteString("12:cpu:/docker/" + id + "\n")
+	file.Close()
+
+	savedFile := cgroupFile
+	cgroupFile = file.Name()
+	defer func() { cgroupFile = savedFile }()
+
+	if result := containerID(); result != id {
+		t.Errorf("Expected %q, got %q", id, result)
+	}
+}
+
+func TestSetServiceInfo(t *testing.T) {
+	saved := CurrentServiceInfo()
+	defer func() {
+		serviceInfoMu.Lock()
+		serviceInfo = saved
+		serviceInfoMu.Unlock()
+	}()
+
+	SetServiceInfo(ServiceInfo{Version: "v2.0.0", Environment: "staging"})
+	handler := memory.New()
+	NewApexLogServiceContextWithHandler(handler).Info("Hello")
+
+	entry := handler.Entries[0]
+	if entry.Fields.Get("version") != "v2.0.0" || entry.Fields.Get("environment") != "staging" {
+		t.Errorf("Expected overridden fields, got %v", entry.Fields)
+	}
+	if entry.Fields.Get("service") != saved.Name {
+		t.Errorf("Expected service %q to be kept, got %v", saved.Name, entry.Fields.Get("service"))
+	}
+}
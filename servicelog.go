@@ -2,10 +2,8 @@ package apexovernsq
 
 import (
 	"errors"
-	"fmt"
 	"os"
 	"path"
-	"sort"
 	"sync"
 
 	"github.com/apex/log"
@@ -16,17 +14,7 @@ func processName() string {
 }
 
 func appendServiceFieldsToEntry(entry *log.Entry) *log.Entry {
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.WithError(err).Warn("Unable to get hostname for service logging")
-	}
-	return entry.WithFields(
-		log.Fields{
-			"service":  processName(),
-			"hostname": hostname,
-			"pid":      fmt.Sprintf("%d", os.Getpid()),
-		})
-
+	return entry.WithFields(CurrentServiceInfo())
 }
 
 // Create a new logging context with service information.
@@ -46,31 +34,160 @@ func NewApexLogServiceContextWithHandler(handler log.Handler) *log.Entry {
 	return appendServiceFieldsToEntry(entry)
 }
 
+// ServiceFilter describes the entries that a
+// ServiceFilterApexLogHandler passes on, according to the service
+// fields set by NewApexLogServiceContext.
+//
+// Every list holds patterns.  A pattern is either a glob, such as
+// "billing-*", or a regular expression prefixed with
+// RegexpPatternPrefix, such as "re:^billing-(eu|us)$".  A plain name is
+// a glob that matches only itself.
+//
+// An entry is passed on if, for each of service, hostname and pid,
+// the include list is empty or has a pattern matching the entry's
+// field, and the exclude list has no pattern matching it.  Entries
+// with an empty service name are always passed on.
+type ServiceFilter struct {
+	Services         []string
+	ExcludeServices  []string
+	Hostnames        []string
+	ExcludeHostnames []string
+	Pids             []string
+	ExcludePids      []string
+}
+
+// fieldFilter is the compiled include and exclude patterns for a
+// single field.
+type fieldFilter struct {
+	field   string
+	include patternList
+	exclude patternList
+}
+
+// matches reports whether the entry's field passes the filter.
+func (f fieldFilter) matches(e *log.Entry) bool {
+	value, ok := fieldString(e, f.field)
+	if len(f.include) > 0 && (!ok || !f.include.matches(value)) {
+		return false
+	}
+	return !ok || !f.exclude.matches(value)
+}
+
+// compile validates the ServiceFilter's patterns and returns a
+// fieldFilter for each field it applies to.
+func (f ServiceFilter) compile() ([]fieldFilter, error) {
+	var filters []fieldFilter
+	for _, spec := range []struct {
+		field            string
+		include, exclude []string
+	}{
+		{"service", f.Services, f.ExcludeServices},
+		{"hostname", f.Hostnames, f.ExcludeHostnames},
+		{"pid", f.Pids, f.ExcludePids},
+	} {
+		if len(spec.include) == 0 && len(spec.exclude) == 0 {
+			continue
+		}
+		include, err := compilePatterns(spec.include)
+		if err != nil {
+			return nil, err
+		}
+		exclude, err := compilePatterns(spec.exclude)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, fieldFilter{field: spec.field, include: include, exclude: exclude})
+	}
+	return filters, nil
+}
+
+// MatchServiceFilter returns a MatchFunc that matches the entries
+// described by filter.  Unlike ServiceFilterApexLogHandler, entries
+// without a service name are treated like any other.  An error is
+// returned if any of the filter's patterns are invalid.
+func MatchServiceFilter(filter ServiceFilter) (MatchFunc, error) {
+	filters, err := filter.compile()
+	if err != nil {
+		return nil, err
+	}
+	matchers := make([]MatchFunc, 0, len(filters))
+	for _, f := range filters {
+		matchers = append(matchers, f.matches)
+	}
+	return MatchAll(matchers...), nil
+}
+
+// ServiceFilterApexLogHandler is a github.com/apex/log.Handler that
+// only passes on entries from selected services, hosts and processes.
 type ServiceFilterApexLogHandler struct {
 	mu      sync.Mutex
-	filter  *[]string
+	filters []fieldFilter
 	handler log.Handler
 }
 
+// NewApexLogServiceFilterHandler returns a ServiceFilterApexLogHandler
+// that passes on entries whose service matches one of the patterns
+// in filter.  A nil or empty filter passes on every entry.  The
+// filter is copied, so the caller is free to change it afterwards.
+//
+// This constructor can't return an error, and before patterns were
+// supported it matched service names exactly, so a pattern that can't
+// be compiled still matches an identical service name.  The error is
+// logged, so that a typo in a "re:" pattern doesn't silently filter
+// everything out.  Use NewApexLogServiceFilterHandlerWithFilter to
+// have invalid patterns rejected instead.
 func NewApexLogServiceFilterHandler(handler log.Handler, filter *[]string) *ServiceFilterApexLogHandler {
-	if filter != nil {
-		sort.Strings(*filter)
+	h := &ServiceFilterApexLogHandler{
+		handler: handler,
+	}
+	if filter == nil || len(*filter) == 0 {
+		return h
+	}
+
+	include := make(patternList, 0, len(*filter))
+	for _, pattern := range *filter {
+		match, err := CompilePattern(pattern)
+		if err != nil {
+			backupLogger.WithError(err).Error("ServiceFilterApexLogHandler will only match the pattern exactly")
+			name := pattern
+			match = func(s string) bool { return s == name }
+		}
+		include = append(include, match)
 	}
+	h.filters = []fieldFilter{{field: "service", include: include}}
+	return h
+}
 
+// NewApexLogServiceFilterHandlerWithFilter returns a
+// ServiceFilterApexLogHandler that passes on the entries described by
+// filter.  An error is returned if any of the filter's patterns are
+// invalid.
+func NewApexLogServiceFilterHandlerWithFilter(handler log.Handler, filter ServiceFilter) (*ServiceFilterApexLogHandler, error) {
+	filters, err := filter.compile()
+	if err != nil {
+		return nil, err
+	}
 	return &ServiceFilterApexLogHandler{
 		handler: handler,
-		filter:  filter,
-	}
+		filters: filters,
+	}, nil
 }
 
 func (h *ServiceFilterApexLogHandler) shouldLog(e *log.Entry, serviceName string) bool {
-	if h.filter == nil || len(*h.filter) == 0 || serviceName == "" {
+	if serviceName == "" {
 		return true
 	}
-	index := sort.SearchStrings(*h.filter, serviceName)
-	return index < len(*h.filter) && (*h.filter)[index] == serviceName
+	for _, filter := range h.filters {
+		if !filter.matches(e) {
+			return false
+		}
+	}
+	return true
 }
 
+// HandleLog makes ServiceFilterApexLogHandler fulfil the interface
+// required by github.com/apex/log for handlers.  An error is returned
+// for entries that have no service name, or one that isn't a string.
 func (h *ServiceFilterApexLogHandler) HandleLog(e *log.Entry) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
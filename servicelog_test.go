@@ -26,8 +26,9 @@ func TestNewServiceLogContext(t *testing.T) {
 	ctx.Info("Hello")
 
 	entry := handler.Entries[0]
-	if len(entry.Fields) != 3 {
-		t.Fatalf("Expected 3 fields, got %d", len(entry.Fields))
+	expectedFieldCount := len(CurrentServiceInfo().Fields())
+	if len(entry.Fields) != expectedFieldCount {
+		t.Fatalf("Expected %d fields, got %d", expectedFieldCount, len(entry.Fields))
 	}
 
 	serviceName := entry.Fields.Get("service")
@@ -77,3 +78,105 @@ func TestServiceFilterApexLogHandlerNoFilter(t *testing.T) {
 		t.Errorf("Expected %d entries, got %d", 1, resultCount)
 	}
 }
+
+// NewApexLogServiceFilterHandler must not reorder the caller's filter.
+func TestServiceFilterApexLogHandlerLeavesFilterAlone(t *testing.T) {
+	filter := []string{"zebra", "aardvark"}
+	NewApexLogServiceFilterHandler(memory.New(), &filter)
+	if filter[0] != "zebra" || filter[1] != "aardvark" {
+		t.Errorf("Expected filter to be unchanged, got %q", filter)
+	}
+}
+
+func TestServiceFilterApexLogHandlerWithFilter(t *testing.T) {
+	entries := []log.Fields{
+		{"service": "billing-eu", "hostname": "web1", "pid": "10"},
+		{"service": "billing-us", "hostname": "web2", "pid": "20"},
+		{"service": "billing-test", "hostname": "web1", "pid": "30"},
+		{"service": "checkout", "hostname": "db1", "pid": "40"},
+	}
+
+	var caseTable = []struct {
+		filter   ServiceFilter
+		expected []string
+	}{
+		{ServiceFilter{}, []string{"10", "20", "30", "40"}},
+		{ServiceFilter{Services: []string{"billing-*"}}, []string{"10", "20", "30"}},
+		{ServiceFilter{Services: []string{"billing-*"}, ExcludeServices: []string{"*-test"}}, []string{"10", "20"}},
+		{ServiceFilter{Services: []string{"re:-(eu|us)$"}}, []string{"10", "20"}},
+		{ServiceFilter{Hostnames: []string{"web*"}, ExcludePids: []string{"10"}}, []string{"20", "30"}},
+		{ServiceFilter{Pids: []string{"40"}}, []string{"40"}},
+	}
+
+	for caseNum, testCase := range caseTable {
+		mem := memory.New()
+		handler, err := NewApexLogServiceFilterHandlerWithFilter(mem, testCase.filter)
+		if err != nil {
+			t.Fatalf("[Case %d] Unexpected error: %s", caseNum, err)
+		}
+		for _, fields := range entries {
+			handler.HandleLog(&log.Entry{Fields: fields})
+		}
+		var pids []string
+		for _, entry := range mem.Entries {
+			pids = append(pids, entry.Fields.Get("pid").(string))
+		}
+		if fmt.Sprint(pids) != fmt.Sprint(testCase.expected) {
+			t.Errorf("[Case %d] Expected pids %q, got %q", caseNum, testCase.expected, pids)
+		}
+	}
+}
+
+func TestServiceFilterApexLogHandlerWithInvalidFilter(t *testing.T) {
+	_, err := NewApexLogServiceFilterHandlerWithFilter(memory.New(), ServiceFilter{Services: []string{"re:("}})
+	if err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+	_, err = NewApexLogServiceFilterHandlerWithFilter(memory.New(), ServiceFilter{ExcludeHostnames: []string{"web["}})
+	if err == nil {
+		t.Error("Expected an error for an invalid glob")
+	}
+}
+
+func TestMatchServiceFilter(t *testing.T) {
+	match, err := MatchServiceFilter(ServiceFilter{Services: []string{"billing-*"}, ExcludeHostnames: []string{"canary*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		fields   log.Fields
+		expected bool
+	}{
+		{log.Fields{"service": "billing-eu", "hostname": "web1"}, true},
+		{log.Fields{"service": "billing-eu", "hostname": "canary1"}, false},
+		{log.Fields{"service": "auth", "hostname": "web1"}, false},
+		{log.Fields{}, false},
+	}
+	for caseNum, testCase := range cases {
+		if result := match(&log.Entry{Fields: testCase.fields}); result != testCase.expected {
+			t.Errorf("[Case %d] Expected %t, got %t", caseNum, testCase.expected, result)
+		}
+	}
+
+	if _, err := MatchServiceFilter(ServiceFilter{Pids: []string{"re:("}}); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+}
+
+func TestServiceFilterApexLogHandlerWithInvalidPattern(t *testing.T) {
+	saved := backupLogger
+	defer func() { backupLogger = saved }()
+	backupHandler := memory.New()
+	backupLogger = log.Logger{Handler: backupHandler, Level: log.InfoLevel}
+
+	mem := memory.New()
+	handler := NewApexLogServiceFilterHandler(mem, &[]string{"re:("})
+	if count := len(backupHandler.Entries); count != 1 {
+		t.Fatalf("Expected the invalid pattern to be logged, got %d entries", count)
+	}
+	handler.HandleLog(&log.Entry{Message: "exact", Fields: log.Fields{"service": "re:("}})
+	handler.HandleLog(&log.Entry{Message: "other", Fields: log.Fields{"service": "billing"}})
+	if len(mem.Entries) != 1 || mem.Entries[0].Message != "exact" {
+		t.Errorf("Expected only the exact match to be passed on, got %+v", mem.Entries)
+	}
+}
@@ -0,0 +1,195 @@
+//go:build go1.21
+// +build go1.21
+
+package apexovernsq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// SlogHandlerOptions tunes a SlogHandler.
+type SlogHandlerOptions struct {
+	// Level is the minimum level of record that will be passed
+	// on.  It defaults to slog.LevelInfo.
+	Level slog.Leveler
+	// OmitServiceFields prevents the fields described by
+	// CurrentServiceInfo from being added to every entry.
+	OmitServiceFields bool
+}
+
+// SlogHandler is a log/slog.Handler that converts each slog.Record
+// into a github.com/apex/log.Entry and passes it to an apex log
+// handler, typically an ApexLogNSQHandler or AsyncApexLogNSQHandler.
+// This allows code written against log/slog to join the same NSQ log
+// pipeline as code using apex log.
+//
+// Attributes become fields.  Attributes inside groups become fields
+// with dotted names, so the attribute "id" in the group "user" becomes
+// the field "user.id".  Strings, numbers, booleans, durations and
+// times keep their types; errors are stored as their message, and
+// any other value is stored as its fmt.Stringer representation, or
+// failing that as formatted by fmt.Sprint.
+type SlogHandler struct {
+	handler log.Handler
+	logger  *log.Logger
+	level   slog.Leveler
+	fields  log.Fields
+	prefix  string
+}
+
+// NewSlogHandler returns a pointer to a SlogHandler that passes
+// records to the provided apex log handler.  Options may be nil.
+//
+//	logger := slog.New(apexovernsq.NewSlogHandler(nsqHandler, nil))
+func NewSlogHandler(handler log.Handler, options *SlogHandlerOptions) *SlogHandler {
+	if options == nil {
+		options = &SlogHandlerOptions{}
+	}
+	level := options.Level
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	fields := log.Fields{}
+	if !options.OmitServiceFields {
+		fields = CurrentServiceInfo().Fields()
+	}
+	return &SlogHandler{
+		handler: handler,
+		logger:  &log.Logger{Handler: handler, Level: log.DebugLevel},
+		level:   level,
+		fields:  fields,
+	}
+}
+
+// apexLevel maps a slog.Level onto the nearest github.com/apex/log
+// level that is no more severe.
+func apexLevel(level slog.Level) log.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return log.DebugLevel
+	case level < slog.LevelWarn:
+		return log.InfoLevel
+	case level < slog.LevelError:
+		return log.WarnLevel
+	default:
+		return log.ErrorLevel
+	}
+}
+
+// attrValue converts a slog.Value into a value for an apex log field.
+func attrValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindUint64:
+		return v.Uint64()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return v.Duration()
+	case slog.KindTime:
+		return v.Time()
+	}
+	switch value := v.Any().(type) {
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// addAttr adds an attribute, and any attributes grouped within it, to
+// fields.  Every field name is given the provided prefix.
+func addAttr(fields log.Fields, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if a.Key != "" {
+			groupPrefix = prefix + a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			addAttr(fields, groupPrefix, member)
+		}
+		return
+	}
+	fields[prefix+a.Key] = attrValue(a.Value)
+}
+
+// Enabled reports whether records at the given level will be passed
+// on.
+func (h *SlogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle converts the record into a github.com/apex/log.Entry and
+// passes it on.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := make(log.Fields, len(h.fields)+r.NumAttrs())
+	for key, value := range h.fields {
+		fields[key] = value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(fields, h.prefix, a)
+		return true
+	})
+
+	timestamp := r.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	return h.handler.HandleLog(&log.Entry{
+		Logger:    h.logger,
+		Fields:    fields,
+		Level:     apexLevel(r.Level),
+		Timestamp: timestamp,
+		Message:   r.Message,
+	})
+}
+
+// clone returns a copy of the SlogHandler with its own fields.
+func (h *SlogHandler) clone() *SlogHandler {
+	c := *h
+	c.fields = make(log.Fields, len(h.fields))
+	for key, value := range h.fields {
+		c.fields[key] = value
+	}
+	return &c
+}
+
+// WithAttrs returns a SlogHandler that adds the attributes to every
+// entry it passes on.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	c := h.clone()
+	for _, a := range attrs {
+		addAttr(c.fields, c.prefix, a)
+	}
+	return c
+}
+
+// WithGroup returns a SlogHandler that prefixes the names of all
+// subsequent attributes with the group's name.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := h.clone()
+	c.prefix = h.prefix + name + "."
+	return c
+}
@@ -0,0 +1,76 @@
+//go:build go1.21
+// +build go1.21
+
+package apexovernsq
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"code.avct.io/apexovernsq/protobuf"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestSlogHandler(t *testing.T) {
+	mem := memory.New()
+	logger := slog.New(NewSlogHandler(mem, &SlogHandlerOptions{Level: slog.LevelDebug}))
+
+	logger.With("component", "cart").WithGroup("user").Info("checked out",
+		"id", 42,
+		slog.Group("address", "country", "NL"),
+		"vip", true,
+		"wait", time.Second,
+	)
+	logger.Debug("details", "err", errors.New("oops"))
+	logger.Log(context.Background(), slog.LevelWarn+2, "between levels")
+
+	if count := len(mem.Entries); count != 3 {
+		t.Fatalf("Expected 3 entries, got %d", count)
+	}
+	entry := mem.Entries[0]
+	if entry.Message != "checked out" || entry.Level != log.InfoLevel {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	expected := log.Fields{
+		"component":            "cart",
+		"user.id":              int64(42),
+		"user.address.country": "NL",
+		"user.vip":             true,
+		"user.wait":            time.Second,
+		"service":              expectedServiceName,
+	}
+	for name, value := range expected {
+		if entry.Fields.Get(name) != value {
+			t.Errorf("Expected %s=%v, got %s=%v", name, value, name, entry.Fields.Get(name))
+		}
+	}
+	if err := mem.Entries[1].Fields.Get("err"); err != "oops" {
+		t.Errorf("Expected err=oops, got %v", err)
+	}
+	if level := mem.Entries[2].Level; level != log.WarnLevel {
+		t.Errorf("Expected warn, got %s", level)
+	}
+
+	if _, err := protobuf.Marshal(entry); err != nil {
+		t.Errorf("Expected converted entry to be marshallable, got %s", err)
+	}
+}
+
+func TestSlogHandlerLevelAndServiceFields(t *testing.T) {
+	mem := memory.New()
+	logger := slog.New(NewSlogHandler(mem, &SlogHandlerOptions{OmitServiceFields: true}))
+	logger.Debug("hidden")
+	logger.Info("shown")
+
+	if count := len(mem.Entries); count != 1 {
+		t.Fatalf("Expected 1 entry, got %d", count)
+	}
+	if service := mem.Entries[0].Fields.Get("service"); service != nil {
+		t.Errorf("Expected no service field, got %v", service)
+	}
+}
@@ -0,0 +1,207 @@
+package apexovernsq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/go-logfmt/logfmt"
+)
+
+// LogWriterOptions tunes how a LogWriter interprets the lines written
+// to it.
+type LogWriterOptions struct {
+	// DetectJSON causes lines holding a JSON object to be
+	// unpacked into the entry's fields.
+	DetectJSON bool
+	// DetectLogfmt causes lines made entirely of logfmt key=value
+	// pairs to be unpacked into the entry's fields.
+	DetectLogfmt bool
+}
+
+// messageKeys and levelKeys are the keys recognised as holding the
+// message and the level, when a line is unpacked into fields.
+var (
+	messageKeys = []string{"msg", "message"}
+	levelKeys   = []string{"level", "lvl", "severity"}
+)
+
+// LogWriter is an io.Writer that turns each line written to it into a
+// github.com/apex/log entry.  It allows output from code that uses the
+// standard library's log package, or writes to an io.Writer, to join
+// the NSQ log pipeline:
+//
+//	stdlog.SetFlags(0)
+//	stdlog.SetOutput(apexovernsq.NewLogWriter(nil, alog.InfoLevel, apexovernsq.LogWriterOptions{}))
+//
+// The standard library's log flags add a timestamp to each line,
+// which is redundant once the line becomes an entry, so they are best
+// set to zero.  Beware of installing a LogWriter as the standard
+// library's output whilst the apex log handler is its default, as
+// that handler itself writes to the standard library's log.
+type LogWriter struct {
+	mu      sync.Mutex
+	entry   *log.Entry
+	level   log.Level
+	options LogWriterOptions
+	buf     []byte
+}
+
+// NewLogWriter returns a pointer to a LogWriter that logs each line
+// through entry, or through NewApexLogServiceContext if entry is nil.
+// Lines are logged at the given level, unless they are unpacked
+// according to the options and specify a level of their own.  Fatal
+// entries are logged at error level, as the process is left to the
+// writer's caller to end.
+func NewLogWriter(entry *log.Entry, level log.Level, options LogWriterOptions) *LogWriter {
+	if entry == nil {
+		entry = NewApexLogServiceContext()
+	}
+	return &LogWriter{
+		entry:   entry,
+		level:   level,
+		options: options,
+	}
+}
+
+// Write makes LogWriter implement io.Writer.  Every complete line in
+// p is logged; any trailing partial line is held until it's completed
+// by a later Write, or until Flush is called.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs any partial line that is being held.
+func (w *LogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+}
+
+// logLine turns a single line into an entry and logs it.
+func (w *LogWriter) logLine(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+
+	var fields log.Fields
+	var ok bool
+	if w.options.DetectJSON {
+		fields, ok = parseJSONLine(line)
+	}
+	if !ok && w.options.DetectLogfmt {
+		fields, ok = parseLogfmtLine(line)
+	}
+	if !ok {
+		w.log(w.entry, w.level, string(line))
+		return
+	}
+
+	msg := takeString(fields, messageKeys)
+	level := w.level
+	if name := takeString(fields, levelKeys); name != "" {
+		if parsed, err := log.ParseLevel(name); err == nil {
+			level = parsed
+		} else {
+			fields["level"] = name
+		}
+	}
+	w.log(w.entry.WithFields(fields), level, msg)
+}
+
+// log makes an entry at the given level.
+func (w *LogWriter) log(entry *log.Entry, level log.Level, msg string) {
+	switch level {
+	case log.DebugLevel:
+		entry.Debug(msg)
+	case log.InfoLevel:
+		entry.Info(msg)
+	case log.WarnLevel:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
+// takeString removes the first of keys found in fields and returns
+// its value as a string.
+func takeString(fields log.Fields, keys []string) string {
+	for _, key := range keys {
+		if value, ok := fields[key]; ok {
+			delete(fields, key)
+			if str, ok := value.(string); ok {
+				return str
+			}
+			return fmt.Sprint(value)
+		}
+	}
+	return ""
+}
+
+// parseJSONLine unpacks a line holding a JSON object into fields.
+// Nested objects and arrays are kept as JSON text.
+func parseJSONLine(line []byte) (log.Fields, bool) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, false
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &object); err != nil {
+		return nil, false
+	}
+	fields := make(log.Fields, len(object))
+	for key, raw := range object {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, false
+		}
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			fields[key] = string(raw)
+		case nil:
+			fields[key] = ""
+		default:
+			fields[key] = value
+		}
+	}
+	return fields, true
+}
+
+// parseLogfmtLine unpacks a line made entirely of logfmt key=value
+// pairs into fields.  Lines containing bare words, which is to say
+// most plain text, are rejected.
+func parseLogfmtLine(line []byte) (log.Fields, bool) {
+	decoder := logfmt.NewDecoder(bytes.NewReader(line))
+	fields := log.Fields{}
+	for decoder.ScanRecord() {
+		for decoder.ScanKeyval() {
+			if decoder.Value() == nil {
+				return nil, false
+			}
+			fields[string(decoder.Key())] = string(decoder.Value())
+		}
+	}
+	if decoder.Err() != nil || len(fields) == 0 {
+		return nil, false
+	}
+	return fields, true
+}
@@ -0,0 +1,79 @@
+package apexovernsq
+
+import (
+	"fmt"
+	stdlog "log"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+)
+
+func TestLogWriterPlainLines(t *testing.T) {
+	mem := memory.New()
+	writer := NewLogWriter(NewApexLogServiceContextWithHandler(mem), log.WarnLevel, LogWriterOptions{})
+	logger := stdlog.New(writer, "", 0)
+
+	logger.Print("connection reset")
+	fmt.Fprint(writer, "partial ")
+	fmt.Fprint(writer, "line\nleft over")
+	if count := len(mem.Entries); count != 2 {
+		t.Fatalf("Expected 2 entries, got %d", count)
+	}
+	writer.Flush()
+
+	expected := []string{"connection reset", "partial line", "left over"}
+	for i, entry := range mem.Entries {
+		if entry.Message != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], entry.Message)
+		}
+		if entry.Level != log.WarnLevel {
+			t.Errorf("Expected warn, got %s", entry.Level)
+		}
+		if entry.Fields.Get("service") != expectedServiceName {
+			t.Errorf("Expected service fields, got %v", entry.Fields)
+		}
+	}
+}
+
+func TestLogWriterStructuredLines(t *testing.T) {
+	mem := memory.New()
+	writer := NewLogWriter(NewApexLogServiceContextWithHandler(mem), log.InfoLevel, LogWriterOptions{
+		DetectJSON:   true,
+		DetectLogfmt: true,
+	})
+
+	fmt.Fprintln(writer, `{"msg": "cache miss", "level": "warn", "key": "user:1", "size": 3, "tags": ["a"]}`)
+	fmt.Fprintln(writer, `level=error msg="disk full" device=sda1`)
+	fmt.Fprintln(writer, `listening on port=8080`)
+	fmt.Fprintln(writer, `{not json`)
+
+	if count := len(mem.Entries); count != 4 {
+		t.Fatalf("Expected 4 entries, got %d", count)
+	}
+
+	var caseTable = []struct {
+		message string
+		level   log.Level
+		fields  log.Fields
+	}{
+		{"cache miss", log.WarnLevel, log.Fields{"key": "user:1", "size": float64(3), "tags": `["a"]`}},
+		{"disk full", log.ErrorLevel, log.Fields{"device": "sda1"}},
+		{"listening on port=8080", log.InfoLevel, log.Fields{}},
+		{"{not json", log.InfoLevel, log.Fields{}},
+	}
+	for i, testCase := range caseTable {
+		entry := mem.Entries[i]
+		if entry.Message != testCase.message || entry.Level != testCase.level {
+			t.Errorf("[Case %d] Expected %s %q, got %s %q", i, testCase.level, testCase.message, entry.Level, entry.Message)
+		}
+		for name, value := range testCase.fields {
+			if entry.Fields.Get(name) != value {
+				t.Errorf("[Case %d] Expected %s=%v, got %s=%v", i, name, value, name, entry.Fields.Get(name))
+			}
+		}
+		if _, ok := entry.Fields["msg"]; ok {
+			t.Errorf("[Case %d] Expected msg to become the message, not a field", i)
+		}
+	}
+}